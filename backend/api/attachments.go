@@ -0,0 +1,318 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/media"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+	"github.com/Kenzhe14/chat/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxAttachmentsPerMessage caps how many uploads one message can carry.
+const maxAttachmentsPerMessage = 10
+
+var errInvalidAttachments = errors.New("invalid attachments")
+
+// inlineImageTypes are served inline so clients can display them directly.
+var inlineImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// attachmentTypeAllowed reports whether mimeType matches the room's
+// comma-separated allowlist, which may contain wildcards like "image/*".
+// An empty allowlist permits any type.
+func attachmentTypeAllowed(allowed, mimeType string) bool {
+	if strings.TrimSpace(allowed) == "" {
+		return true
+	}
+	for _, pattern := range strings.Split(allowed, ",") {
+		pattern = strings.TrimSpace(strings.ToLower(pattern))
+		if pattern == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func newStorageKey(roomID uint) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rooms/%d/%s/%s", roomID, time.Now().Format("2006/01/02"), hex.EncodeToString(buf)), nil
+}
+
+// deleteBlobs removes stored files, logging failures. It is called after the
+// attachment rows are gone, so a leftover blob is never reachable.
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := storage.Store.Delete(context.Background(), key); err != nil {
+			log.Printf("Error deleting blob %s: %v", key, err)
+		}
+	}
+}
+
+// deleteMessageAttachments removes the attachment rows of the given messages
+// and returns their storage keys, thumbnails included.
+func deleteMessageAttachments(tx *gorm.DB, messageIDs []uint) ([]string, error) {
+	var attachments []models.Attachment
+	if err := tx.Where("message_id IN ?", messageIDs).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("message_id IN ?", messageIDs).Delete(&models.Attachment{}).Error; err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, a := range attachments {
+		keys = append(keys, a.BlobKeys()...)
+	}
+	return keys, nil
+}
+
+// linkAttachments attaches the caller's pending uploads to a new message.
+func linkAttachments(tx *gorm.DB, message *models.Message, attachmentIDs []uint) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	result := tx.Model(&models.Attachment{}).
+		Where("id IN ? AND uploader_id = ? AND room_id = ? AND message_id IS NULL", attachmentIDs, message.UserID, message.RoomID).
+		Update("message_id", message.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(attachmentIDs)) {
+		return errInvalidAttachments
+	}
+	return tx.Where("message_id = ?", message.ID).Order("id").Find(&message.Attachments).Error
+}
+
+// UploadAttachment stores a file sent as the multipart "file" field and
+// returns a pending attachment. Pass its ID in "attachment_ids" when creating
+// the message. The MIME type is sniffed from the content; the room's
+// max_attachment_bytes and allowed_attachment_types limits apply.
+func UploadAttachment(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return
+	}
+
+	if getRoomRole(&room, userID) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Вы не являетесь участником этой комнаты"})
+		return
+	}
+
+	// The upload is not a send yet; the message it is attached to is.
+	if perr := checkPostingRules(&room, userID, false); perr != nil {
+		respondPostingError(c, perr)
+		return
+	}
+
+	// Leave room for the multipart framing around the file itself.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, room.MaxAttachmentBytes+1<<20)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не найден в запросе"})
+		return
+	}
+
+	if fileHeader.Size > room.MaxAttachmentBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Файл слишком большой", "max_bytes": room.MaxAttachmentBytes})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось прочитать файл"})
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось прочитать файл"})
+		return
+	}
+	head = head[:n]
+
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !attachmentTypeAllowed(room.AllowedAttachmentTypes, mimeType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Тип файла не разрешен в этой комнате", "mime_type": mimeType})
+		return
+	}
+
+	key, err := newStorageKey(room.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при сохранении файла"})
+		return
+	}
+
+	hash := sha256.New()
+	reader := io.TeeReader(io.MultiReader(bytes.NewReader(head), file), hash)
+	if err := storage.Store.Put(c.Request.Context(), key, reader, fileHeader.Size, mimeType); err != nil {
+		log.Printf("Error storing attachment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при сохранении файла"})
+		return
+	}
+
+	attachment := models.Attachment{
+		RoomID:     room.ID,
+		UploaderID: userID,
+		FileName:   filepath.Base(fileHeader.Filename),
+		MimeType:   mimeType,
+		Size:       fileHeader.Size,
+		Checksum:   hex.EncodeToString(hash.Sum(nil)),
+		StorageKey: key,
+		CreatedAt:  time.Now(),
+	}
+	if err := db.DB.Create(&attachment).Error; err != nil {
+		deleteBlobs([]string{key})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при сохранении файла"})
+		return
+	}
+	attachment.URL = fmt.Sprintf("/api/attachments/%d", attachment.ID)
+
+	if media.Supported(mimeType) {
+		if err := services.PublishMediaJob(services.MediaJob{AttachmentID: attachment.ID}); err != nil {
+			log.Printf("Error queueing media job for attachment %d: %v", attachment.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// findAccessibleAttachment loads the attachment named by the :id param if
+// the caller may read it: members of the room it was posted in, or only the
+// uploader while it is still pending. It writes the error response itself.
+func findAccessibleAttachment(c *gin.Context) (*models.Attachment, bool) {
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID файла"})
+		return nil, false
+	}
+
+	userID := c.GetUint("user_id")
+
+	var attachment models.Attachment
+	if err := db.DB.First(&attachment, attachmentID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+		return nil, false
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, attachment.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+		return nil, false
+	}
+
+	if getRoomRole(&room, userID) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return nil, false
+	}
+
+	if attachment.MessageID == nil {
+		if attachment.UploaderID != userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+			return nil, false
+		}
+	} else {
+		var message models.Message
+		if err := db.DB.First(&message, *attachment.MessageID).Error; err != nil || message.IsDeleted {
+			c.JSON(http.StatusGone, gin.H{"error": "Сообщение удалено"})
+			return nil, false
+		}
+	}
+
+	return &attachment, true
+}
+
+func serveBlob(c *gin.Context, key string, size int64, contentType string, headers map[string]string) {
+	reader, err := storage.Store.Get(c.Request.Context(), key)
+	if err == storage.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading blob %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при чтении файла"})
+		return
+	}
+	defer reader.Close()
+
+	headers["X-Content-Type-Options"] = "nosniff"
+	c.DataFromReader(http.StatusOK, size, contentType, reader, headers)
+}
+
+// DownloadAttachment streams a file to members of the room it was posted in.
+// Pending uploads are only visible to the uploader.
+func DownloadAttachment(c *gin.Context) {
+	attachment, ok := findAccessibleAttachment(c)
+	if !ok {
+		return
+	}
+
+	disposition := "attachment"
+	if inlineImageTypes[attachment.MimeType] {
+		disposition = "inline"
+	}
+
+	serveBlob(c, attachment.StorageKey, attachment.Size, attachment.MimeType, map[string]string{
+		"Content-Disposition": mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}),
+		"ETag":                `"` + attachment.Checksum + `"`,
+	})
+}
+
+// DownloadThumbnail serves the JPEG preview generated for an image
+// attachment, under the same access rules as DownloadAttachment.
+func DownloadThumbnail(c *gin.Context) {
+	attachment, ok := findAccessibleAttachment(c)
+	if !ok {
+		return
+	}
+
+	if attachment.ThumbnailKey == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Превью не найдено"})
+		return
+	}
+
+	serveBlob(c, attachment.ThumbnailKey, -1, "image/jpeg", map[string]string{
+		"Content-Disposition": "inline",
+		"ETag":                `"` + attachment.Checksum + `-thumb"`,
+	})
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+type roomSettingsSnapshot struct {
+	Name                   string `json:"name"`
+	Description            string `json:"description"`
+	IsPrivate              bool   `json:"is_private"`
+	SlowModeSeconds        int    `json:"slow_mode_seconds"`
+	PostingPolicy          string `json:"posting_policy"`
+	EditWindowSeconds      int    `json:"edit_window_seconds"`
+	MaxAttachmentBytes     int64  `json:"max_attachment_bytes"`
+	AllowedAttachmentTypes string `json:"allowed_attachment_types"`
+	MessageTTLSeconds      int    `json:"message_ttl_seconds"`
+	OwnerID                uint   `json:"owner_id"`
+}
+
+type roomMemberSnapshot struct {
+	UserID     uint       `json:"user_id"`
+	Role       string     `json:"role"`
+	InvitedBy  uint       `json:"invited_by"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+func roomSettings(room models.Room) *roomSettingsSnapshot {
+	return &roomSettingsSnapshot{
+		Name:                   room.Name,
+		Description:            room.Description,
+		IsPrivate:              room.IsPrivate,
+		SlowModeSeconds:        room.SlowModeSeconds,
+		PostingPolicy:          room.PostingPolicy,
+		EditWindowSeconds:      room.EditWindowSeconds,
+		MaxAttachmentBytes:     room.MaxAttachmentBytes,
+		AllowedAttachmentTypes: room.AllowedAttachmentTypes,
+		MessageTTLSeconds:      room.MessageTTLSeconds,
+		OwnerID:                room.OwnerID,
+	}
+}
+
+func roomMemberState(member models.RoomMember) *roomMemberSnapshot {
+	return &roomMemberSnapshot{
+		UserID:     member.UserID,
+		Role:       member.Role,
+		InvitedBy:  member.InvitedBy,
+		MutedUntil: member.MutedUntil,
+	}
+}
+
+func auditJSON(state interface{}) (models.JSONText, error) {
+	if state == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return models.JSONText(data), nil
+}
+
+// recordRoomAudit appends an audit entry inside the transaction performing
+// the change. before and after may be nil for creations and deletions.
+// Changes that leave the state as it was are not recorded.
+func recordRoomAudit(tx *gorm.DB, roomID, actorID uint, action string, targetUserID *uint, before, after interface{}) error {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	if before != nil && after != nil && beforeJSON == afterJSON {
+		return nil
+	}
+
+	return tx.Create(&models.RoomAuditEntry{
+		RoomID:       roomID,
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Before:       beforeJSON,
+		After:        afterJSON,
+		CreatedAt:    time.Now(),
+	}).Error
+}
+
+// GetRoomAudit lists a room's audit entries, newest first. Results can be
+// filtered by action, actor_id, target_user_id and a since/until time range;
+// pass the last entry ID as "before" to load the next page.
+func GetRoomAudit(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+		return
+	}
+
+	// Deleted rooms keep their audit log.
+	var room models.Room
+	if err := workspaceRooms(c).Unscoped().First(&room, roomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return
+	}
+
+	if !isRoomAdmin(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Журнал доступен только администраторам комнаты"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	query := db.DB.Where("room_id = ?", room.ID)
+
+	if before := c.Query("before"); before != "" {
+		beforeID, err := strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	for _, param := range []string{"actor_id", "target_user_id"} {
+		if value := c.Query(param); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+				return
+			}
+			query = query.Where(param+" = ?", id)
+		}
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<"} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат даты"})
+				return
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+	}
+
+	var entries []models.RoomAuditEntry
+	if err := query.Order("id DESC").Limit(limit + 1).Preload("Actor").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении журнала"})
+		return
+	}
+
+	hasMore := len(entries) > limit
+	if hasMore {
+		entries = entries[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":  entries,
+		"has_more": hasMore,
+	})
+}
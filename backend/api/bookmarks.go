@@ -0,0 +1,229 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultBookmarkLimit = 50
+	maxBookmarkLimit     = 100
+)
+
+type BookmarkRequest struct {
+	Note     string     `json:"note" binding:"max=500"`
+	RemindAt *time.Time `json:"remind_at"`
+}
+
+// visibleBookmarks selects userID's bookmarks whose message still exists
+// in a room in rooms that the user belongs to. Bookmarks of deleted
+// messages and of rooms the user left are kept but hidden.
+func visibleBookmarks(rooms *gorm.DB, userID uint) *gorm.DB {
+	return db.DB.Where("bookmarks.user_id = ?", userID).
+		Joins("JOIN messages ON messages.id = bookmarks.message_id").
+		Where("messages.deleted_at IS NULL AND messages.removed_at IS NULL").
+		Where("messages.expires_at IS NULL OR messages.expires_at > NOW()").
+		Where("bookmarks.room_id IN (?)", memberRoomIDs(rooms, userID))
+}
+
+// GetBookmarks lists the caller's bookmarks across the workspace's rooms,
+// newest first. Pass the last bookmark's ID as "before" to page.
+func GetBookmarks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultBookmarkLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxBookmarkLimit {
+		limit = maxBookmarkLimit
+	}
+
+	query := visibleBookmarks(workspaceRooms(c), userID)
+	if before := c.Query("before"); before != "" {
+		beforeID, err := strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		query = query.Where("bookmarks.id < ?", beforeID)
+	}
+
+	var bookmarks []models.Bookmark
+	if err := query.Preload("Message", withMessageContent).
+		Preload("Message.Room").
+		Order("bookmarks.id DESC").
+		Limit(limit + 1).
+		Find(&bookmarks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении закладок"})
+		return
+	}
+
+	hasMore := len(bookmarks) > limit
+	if hasMore {
+		bookmarks = bookmarks[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bookmarks": bookmarks,
+		"has_more":  hasMore,
+	})
+}
+
+// BookmarkMessage saves the message for the caller, or updates the note and
+// reminder of an existing bookmark. Setting a new reminder re-arms it.
+func BookmarkMessage(c *gin.Context) {
+	var req BookmarkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RemindAt != nil && !req.RemindAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Время напоминания должно быть в будущем"})
+		return
+	}
+
+	message, ok := loadReactableMessage(c)
+	if !ok {
+		return
+	}
+
+	// Use the same rule as the list, so a saved bookmark is never hidden.
+	userID := c.GetUint("user_id")
+	var joined int64
+	if err := workspaceRooms(c).Model(&models.Room{}).
+		Where("id = ? AND id IN (?)", message.RoomID, memberRoomIDs(db.DB, userID)).
+		Count(&joined).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при сохранении закладки"})
+		return
+	}
+	if joined == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Сохранять в закладки можно только сообщения из ваших комнат"})
+		return
+	}
+
+	now := time.Now()
+	bookmark := models.Bookmark{
+		UserID:    userID,
+		MessageID: message.ID,
+		RoomID:    message.RoomID,
+		Note:      req.Note,
+		RemindAt:  req.RemindAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"note", "remind_at", "reminded_at", "updated_at"}),
+	}).Create(&bookmark).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при сохранении закладки"})
+		return
+	}
+
+	if err := db.DB.Preload("Message", withMessageContent).
+		Where("user_id = ? AND message_id = ?", bookmark.UserID, bookmark.MessageID).
+		First(&bookmark).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при сохранении закладки"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bookmark)
+}
+
+func RemoveBookmark(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return
+	}
+
+	result := db.DB.Where("user_id = ? AND message_id = ?", c.GetUint("user_id"), messageID).
+		Delete(&models.Bookmark{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении закладки"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Закладка не найдена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Закладка удалена", "message_id": messageID})
+}
+
+// sendDueBookmarkReminders handles one batch of due bookmark reminders and
+// returns how many it claimed. Reminders of hidden bookmarks are dropped
+// silently.
+func sendDueBookmarkReminders(manager *services.WebSocketManager) (int, error) {
+	var (
+		due           []models.Bookmark
+		notifications []models.Notification
+	)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("remind_at <= ? AND reminded_at IS NULL", time.Now()).
+			Order("remind_at, id").
+			Limit(reminderBatch).
+			Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(due))
+		for i, b := range due {
+			ids[i] = b.ID
+		}
+
+		now := time.Now()
+		for _, b := range due {
+			var bookmark models.Bookmark
+			err := visibleBookmarks(db.DB, b.UserID).
+				Where("bookmarks.id = ?", b.ID).
+				Preload("Message").
+				First(&bookmark).Error
+			if err != nil {
+				continue
+			}
+
+			preview := bookmark.Note
+			if preview == "" {
+				preview = bookmark.Message.ContentText
+			}
+			notifications = append(notifications, models.Notification{
+				UserID:    bookmark.UserID,
+				Type:      models.NotificationTypeReminder,
+				RoomID:    bookmark.RoomID,
+				MessageID: bookmark.MessageID,
+				ActorID:   bookmark.Message.UserID,
+				Preview:   notificationPreview(preview),
+				CreatedAt: now,
+			})
+		}
+		if len(notifications) > 0 {
+			if err := tx.Create(&notifications).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Bookmark{}).Where("id IN ?", ids).Update("reminded_at", now).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sendReminderNotifications(manager, notifications)
+	return len(due), nil
+}
@@ -0,0 +1,429 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMuteDuration = time.Hour
+	maxMuteDuration     = 30 * 24 * time.Hour
+	maxReminderDelay    = 365 * 24 * time.Hour
+	maxTopicLength      = 250
+)
+
+func builtinCommands() []Command {
+	return []Command{
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "Список доступных команд",
+			Run:         runHelpCommand,
+		},
+		{
+			Name:        "me",
+			Usage:       "/me <действие>",
+			Description: "Отправить сообщение от третьего лица",
+			Run:         runMeCommand,
+		},
+		{
+			Name:        "topic",
+			Usage:       "/topic [тема]",
+			Description: "Показать или изменить тему комнаты",
+			Allowed:     isRoomAdmin,
+			RateLimit:   &CommandRateLimit{Count: 5, Window: 10 * time.Minute},
+			Run:         runTopicCommand,
+		},
+		{
+			Name:        "invite",
+			Usage:       "/invite @пользователь",
+			Description: "Добавить пользователя в комнату",
+			Allowed:     canInviteToRoom,
+			Run:         runInviteCommand,
+		},
+		{
+			Name:        "kick",
+			Usage:       "/kick @пользователь",
+			Description: "Исключить пользователя из комнаты",
+			Allowed:     isRoomAdmin,
+			Run:         runKickCommand,
+		},
+		{
+			Name:        "mute",
+			Usage:       "/mute @пользователь [длительность]",
+			Description: "Запретить пользователю писать в комнате (по умолчанию на 1 час)",
+			Allowed:     isRoomModerator,
+			Run:         runMuteCommand,
+		},
+		{
+			Name:        "unmute",
+			Usage:       "/unmute @пользователь",
+			Description: "Снять запрет на сообщения",
+			Allowed:     isRoomModerator,
+			Run:         runUnmuteCommand,
+		},
+		{
+			Name:        "remind",
+			Usage:       "/remind <через> <текст>",
+			Description: "Напомнить о чем-либо, например: /remind 30m проверить деплой",
+			RateLimit:   &CommandRateLimit{Count: 10, Window: 10 * time.Minute},
+			Run:         runRemindCommand,
+		},
+	}
+}
+
+// canInviteToRoom mirrors AddRoomMember: members may invite to public
+// rooms, only the owner to private ones.
+func canInviteToRoom(room *models.Room, userID uint) bool {
+	if room.IsPrivate {
+		return room.OwnerID == userID
+	}
+	return getRoomRole(room, userID) != ""
+}
+
+// parseCommandDuration reads a Go duration such as "90m" or "2h", or a
+// number of days such as "3d".
+func parseCommandDuration(value string) (time.Duration, bool) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(value)
+	return d, err == nil && d > 0
+}
+
+// commandTarget resolves the @user argument of a command to a member of
+// the room's workspace and returns the remaining arguments.
+func commandTarget(ctx *CommandContext) (models.User, string, *CommandReply) {
+	var target models.User
+	fields := strings.Fields(ctx.Args)
+	if len(fields) == 0 {
+		return target, "", ephemeral("Укажите пользователя")
+	}
+
+	username := strings.TrimPrefix(fields[0], "@")
+	if err := db.DB.Where("LOWER(username) = ?", strings.ToLower(username)).First(&target).Error; err != nil ||
+		getWorkspaceRole(ctx.Room.WorkspaceID, target.ID) == "" {
+		return target, "", ephemeral("Пользователь @%s не найден", username)
+	}
+	return target, strings.Join(fields[1:], " "), nil
+}
+
+func commandFailed(name string, err error) *CommandReply {
+	log.Printf("Error running command /%s: %v", name, err)
+	return ephemeral("Не удалось выполнить команду /%s", name)
+}
+
+func runHelpCommand(ctx *CommandContext) *CommandReply {
+	var b strings.Builder
+	b.WriteString("Доступные команды:")
+	for _, cmd := range allowedCommands(ctx.Room, ctx.User.ID) {
+		usage := cmd.Usage
+		if usage == "" {
+			usage = "/" + cmd.Name
+		}
+		b.WriteString("\n" + usage)
+		if cmd.Description != "" {
+			b.WriteString(" — " + cmd.Description)
+		}
+	}
+	b.WriteString("\nЧтобы отправить сообщение, начинающееся с /, начните его с //")
+	return &CommandReply{Ephemeral: b.String()}
+}
+
+func runMeCommand(ctx *CommandContext) *CommandReply {
+	if ctx.Args == "" {
+		return ephemeral("Использование: /me <действие>")
+	}
+	if ctx.ClientMessageID != "" {
+		existing, err := findClientMessage(ctx.User.ID, ctx.ClientMessageID)
+		if err != nil {
+			return commandFailed("me", err)
+		}
+		if existing != nil {
+			return retriedCommandMessage(ctx, existing)
+		}
+	}
+	if ctx.ParentID != nil {
+		var parent models.Message
+		if err := db.DB.First(&parent, *ctx.ParentID).Error; err != nil || parent.RoomID != ctx.Room.ID || parent.ParentID != nil {
+			return ephemeral("Родительское сообщение не найдено")
+		}
+	}
+	if perr := checkCanPost(ctx.Room, ctx.User.ID); perr != nil {
+		return ephemeral("%s", perr.Message)
+	}
+
+	now := time.Now()
+	message := models.Message{
+		Content:   ctx.Args,
+		Format:    models.MessageFormatPlain,
+		Type:      models.MessageTypeAction,
+		UserID:    ctx.User.ID,
+		RoomID:    ctx.Room.ID,
+		ParentID:  ctx.ParentID,
+		ExpiresAt: messageExpiry(ctx.Room, nil, now),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if ctx.ClientMessageID != "" {
+		message.ClientMessageID = &ctx.ClientMessageID
+	}
+
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockSlowMode(tx, ctx.Room, ctx.User.ID); err != nil {
+			return err
+		}
+		return insertMessage(tx, &message, nil)
+	}); err != nil {
+		var perr *PostingError
+		if errors.As(err, &perr) {
+			return ephemeral("%s", perr.Message)
+		}
+		if ctx.ClientMessageID != "" {
+			// A concurrent retry may have stored the message first.
+			if existing, findErr := findClientMessage(ctx.User.ID, ctx.ClientMessageID); findErr == nil && existing != nil {
+				return retriedCommandMessage(ctx, existing)
+			}
+		}
+		return commandFailed("me", err)
+	}
+	announceMessage(ctx.Manager, &message, ctx.Room, ctx.User)
+
+	return &CommandReply{Message: &message}
+}
+
+// retriedCommandMessage answers a retried /me with the message stored by
+// the first attempt.
+func retriedCommandMessage(ctx *CommandContext, message *models.Message) *CommandReply {
+	if message.RoomID != ctx.Room.ID {
+		return ephemeral("Идентификатор сообщения уже использован")
+	}
+	return &CommandReply{Message: message}
+}
+
+func runTopicCommand(ctx *CommandContext) *CommandReply {
+	if ctx.Args == "" {
+		if ctx.Room.Description == "" {
+			return ephemeral("Тема комнаты не задана")
+		}
+		return ephemeral("Тема комнаты: %s", ctx.Room.Description)
+	}
+	if utf8.RuneCountInString(ctx.Args) > maxTopicLength {
+		return ephemeral("Тема не может быть длиннее %d символов", maxTopicLength)
+	}
+
+	room := *ctx.Room
+	before := roomSettings(room)
+	room.Description = ctx.Args
+	room.UpdatedAt = time.Now()
+
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&room).Updates(map[string]interface{}{
+			"description": room.Description,
+			"updated_at":  room.UpdatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, room.ID, ctx.User.ID, models.AuditRoomUpdate, nil, before, roomSettings(room))
+	}); err != nil {
+		return commandFailed("topic", err)
+	}
+
+	broadcastRoomEvent(ctx.Manager, room.ID, "room_topic_changed", map[string]interface{}{
+		"topic":      room.Description,
+		"changed_by": ctx.User.ID,
+		"username":   ctx.User.Username,
+	})
+	return ephemeral("Тема комнаты изменена")
+}
+
+func runInviteCommand(ctx *CommandContext) *CommandReply {
+	target, _, reply := commandTarget(ctx)
+	if reply != nil {
+		return reply
+	}
+	if target.ID == ctx.Room.OwnerID {
+		return ephemeral("@%s уже является участником комнаты", target.Username)
+	}
+
+	var existing int64
+	if err := db.DB.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", ctx.Room.ID, target.ID).
+		Count(&existing).Error; err != nil {
+		return commandFailed("invite", err)
+	}
+	if existing > 0 {
+		return ephemeral("@%s уже является участником комнаты", target.Username)
+	}
+
+	member := models.RoomMember{
+		RoomID:    ctx.Room.ID,
+		UserID:    target.ID,
+		Role:      models.RoomRoleMember,
+		JoinedAt:  time.Now(),
+		InvitedBy: ctx.User.ID,
+	}
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, ctx.Room.ID, ctx.User.ID, models.AuditMemberAdd, &target.ID, nil, roomMemberState(member))
+	}); err != nil {
+		return commandFailed("invite", err)
+	}
+
+	broadcastRoomEvent(ctx.Manager, ctx.Room.ID, "member_added", map[string]interface{}{
+		"user_id":    target.ID,
+		"username":   target.Username,
+		"invited_by": ctx.User.ID,
+	})
+	return ephemeral("@%s добавлен в комнату", target.Username)
+}
+
+func runKickCommand(ctx *CommandContext) *CommandReply {
+	target, _, reply := commandTarget(ctx)
+	if reply != nil {
+		return reply
+	}
+	if target.ID == ctx.Room.OwnerID {
+		return ephemeral("Нельзя удалить владельца комнаты")
+	}
+	if target.ID == ctx.User.ID {
+		return ephemeral("Нельзя исключить самого себя")
+	}
+
+	var member models.RoomMember
+	if err := db.DB.Where("room_id = ? AND user_id = ?", ctx.Room.ID, target.ID).First(&member).Error; err != nil {
+		return ephemeral("@%s не является участником комнаты", target.Username)
+	}
+	// Only the owner can remove other admins.
+	if member.IsAdmin() && ctx.User.ID != ctx.Room.OwnerID {
+		return ephemeral("У вас нет прав на удаление этого участника")
+	}
+
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("room_id = ? AND user_id = ?", ctx.Room.ID, target.ID).Delete(&models.RoomMember{}).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, ctx.Room.ID, ctx.User.ID, models.AuditMemberRemove, &target.ID, roomMemberState(member), nil)
+	}); err != nil {
+		return commandFailed("kick", err)
+	}
+
+	broadcastRoomEvent(ctx.Manager, ctx.Room.ID, "member_removed", map[string]interface{}{
+		"user_id":    target.ID,
+		"username":   target.Username,
+		"removed_by": ctx.User.ID,
+	})
+	ctx.Manager.DisconnectUser(ctx.Room.ID, target.ID)
+	return ephemeral("@%s исключен из комнаты", target.Username)
+}
+
+// setMemberMute mutes a member until the given time, or unmutes them when
+// until is nil. Moderators cannot be muted.
+func setMemberMute(ctx *CommandContext, name string, until *time.Time) (models.User, *CommandReply) {
+	target, _, reply := commandTarget(ctx)
+	if reply != nil {
+		return target, reply
+	}
+
+	var member models.RoomMember
+	if err := db.DB.Where("room_id = ? AND user_id = ?", ctx.Room.ID, target.ID).First(&member).Error; err != nil {
+		return target, ephemeral("@%s не является участником комнаты", target.Username)
+	}
+	if until != nil && (member.IsModerator() || target.ID == ctx.Room.OwnerID) {
+		return target, ephemeral("Нельзя ограничить модератора")
+	}
+
+	before := roomMemberState(member)
+	member.MutedUntil = until
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.RoomMember{}).
+			Where("room_id = ? AND user_id = ?", ctx.Room.ID, target.ID).
+			Update("muted_until", until).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, ctx.Room.ID, ctx.User.ID, models.AuditMemberMute, &target.ID, before, roomMemberState(member))
+	}); err != nil {
+		return target, commandFailed(name, err)
+	}
+
+	event := map[string]interface{}{
+		"user_id":     target.ID,
+		"username":    target.Username,
+		"muted_until": nil,
+		"changed_by":  ctx.User.ID,
+	}
+	if until != nil {
+		event["muted_until"] = until.Format(time.RFC3339)
+	}
+	broadcastRoomEvent(ctx.Manager, ctx.Room.ID, "member_muted", event)
+	return target, nil
+}
+
+func runMuteCommand(ctx *CommandContext) *CommandReply {
+	duration := defaultMuteDuration
+	if fields := strings.Fields(ctx.Args); len(fields) > 1 {
+		d, ok := parseCommandDuration(fields[1])
+		if !ok || d > maxMuteDuration {
+			return ephemeral("Неверная длительность. Примеры: 10m, 2h, 3d (не более 30 дней)")
+		}
+		duration = d
+	}
+
+	until := time.Now().Add(duration)
+	target, reply := setMemberMute(ctx, "mute", &until)
+	if reply != nil {
+		return reply
+	}
+	return ephemeral("@%s не сможет писать в комнате до %s", target.Username, until.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+func runUnmuteCommand(ctx *CommandContext) *CommandReply {
+	target, reply := setMemberMute(ctx, "unmute", nil)
+	if reply != nil {
+		return reply
+	}
+	return ephemeral("@%s снова может писать в комнате", target.Username)
+}
+
+func runRemindCommand(ctx *CommandContext) *CommandReply {
+	fields := strings.Fields(ctx.Args)
+	if len(fields) > 0 && fields[0] == "in" {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return ephemeral("Использование: /remind <через> <текст>, например: /remind 30m проверить деплой")
+	}
+
+	delay, ok := parseCommandDuration(fields[0])
+	if !ok || delay > maxReminderDelay {
+		return ephemeral("Неверное время. Примеры: 10m, 2h, 3d (не более 365 дней)")
+	}
+
+	now := time.Now()
+	reminder := models.Reminder{
+		UserID:    ctx.User.ID,
+		RoomID:    ctx.Room.ID,
+		Text:      strings.Join(fields[1:], " "),
+		RemindAt:  now.Add(delay),
+		CreatedAt: now,
+	}
+	if err := db.DB.Create(&reminder).Error; err != nil {
+		return commandFailed("remind", err)
+	}
+	return ephemeral("Напомню %s", reminder.RemindAt.UTC().Format("2006-01-02 15:04 UTC"))
+}
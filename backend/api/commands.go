@@ -0,0 +1,210 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+)
+
+// Command is a slash command. Messages starting with "/name" are run on
+// the server instead of being posted; only the caller sees the reply.
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	// Allowed reports whether userID may run the command in room. A nil
+	// Allowed lets everyone who can see the room run it.
+	Allowed func(room *models.Room, userID uint) bool
+	// RateLimit, if set, caps how often one user may run the command.
+	RateLimit *CommandRateLimit
+	Run       func(ctx *CommandContext) *CommandReply
+}
+
+// CommandRateLimit allows Count runs per user within any Window.
+type CommandRateLimit struct {
+	Count  int
+	Window time.Duration
+}
+
+// CommandContext is what a command runs with. Args is the text after the
+// command name. ClientMessageID is the client's ID for the message that
+// carried the command, for commands that post one.
+type CommandContext struct {
+	Manager         *services.WebSocketManager
+	Room            *models.Room
+	User            models.User
+	Args            string
+	ParentID        *uint
+	ClientMessageID string
+}
+
+// CommandReply is sent to the caller only. Message is set when the
+// command posted a message to the room.
+type CommandReply struct {
+	Command   string          `json:"command"`
+	Ephemeral string          `json:"ephemeral,omitempty"`
+	Message   *models.Message `json:"message,omitempty"`
+}
+
+func ephemeral(format string, args ...interface{}) *CommandReply {
+	return &CommandReply{Ephemeral: fmt.Sprintf(format, args...)}
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   = make(map[string]Command)
+
+	commandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+	commandRunsMu    sync.Mutex
+	commandRuns      = make(map[commandRunKey][]time.Time)
+	commandRunsSwept time.Time
+)
+
+type commandRunKey struct {
+	command string
+	userID  uint
+}
+
+// RegisterCommand adds a slash command to the registry, so bots and
+// integrations can extend the built-in commands. Names are
+// case-insensitive and must be unique.
+func RegisterCommand(cmd Command) error {
+	name := strings.ToLower(cmd.Name)
+	if !commandNamePattern.MatchString(name) || cmd.Run == nil {
+		return fmt.Errorf("invalid command %q", cmd.Name)
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	if _, exists := commands[name]; exists {
+		return fmt.Errorf("command /%s is already registered", name)
+	}
+	cmd.Name = name
+	commands[name] = cmd
+	return nil
+}
+
+func init() {
+	for _, cmd := range builtinCommands() {
+		if err := RegisterCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// parseCommand splits "/name args" into its parts. Content starting with
+// "//" escapes the slash and is not a command.
+func parseCommand(content string) (name, args string, ok bool) {
+	content = strings.TrimLeftFunc(content, unicode.IsSpace)
+	if !strings.HasPrefix(content, "/") || strings.HasPrefix(content, "//") {
+		return "", "", false
+	}
+	content = content[1:]
+	end := strings.IndexFunc(content, unicode.IsSpace)
+	if end < 0 {
+		end = len(content)
+	}
+	if end == 0 {
+		return "", "", false
+	}
+	return strings.ToLower(content[:end]), strings.TrimSpace(content[end:]), true
+}
+
+// unescapeCommand turns "//text" into the literal message "/text".
+func unescapeCommand(content string) string {
+	if trimmed := strings.TrimLeftFunc(content, unicode.IsSpace); strings.HasPrefix(trimmed, "//") {
+		return trimmed[1:]
+	}
+	return content
+}
+
+// runCommand runs the named slash command with ctx after checking that
+// the user may, and returns the reply meant for them alone.
+func runCommand(name string, ctx *CommandContext) *CommandReply {
+	commandsMu.RLock()
+	cmd, ok := commands[name]
+	commandsMu.RUnlock()
+
+	var reply *CommandReply
+	switch {
+	case !ok:
+		reply = ephemeral("Неизвестная команда /%s. Введите /help, чтобы увидеть список команд", name)
+	case !canViewRoom(ctx.Room, ctx.User.ID):
+		reply = ephemeral("У вас нет доступа к этой комнате")
+	case cmd.Allowed != nil && !cmd.Allowed(ctx.Room, ctx.User.ID):
+		reply = ephemeral("У вас нет прав на выполнение команды /%s", name)
+	default:
+		if wait := commandRateLimitWait(cmd, ctx.User.ID, time.Now()); wait > 0 {
+			reply = ephemeral("Команду /%s можно повторить через %d сек.", name, int(math.Ceil(wait.Seconds())))
+		} else {
+			reply = cmd.Run(ctx)
+		}
+	}
+	reply.Command = name
+	return reply
+}
+
+// commandRateLimitWait returns how long userID has to wait before running
+// cmd again. A run that is allowed is counted right away.
+func commandRateLimitWait(cmd Command, userID uint, now time.Time) time.Duration {
+	limit := cmd.RateLimit
+	if limit == nil {
+		return 0
+	}
+
+	commandRunsMu.Lock()
+	defer commandRunsMu.Unlock()
+
+	// Forget users whose last run has left its command's window.
+	if now.Sub(commandRunsSwept) > time.Minute {
+		commandsMu.RLock()
+		for key, runs := range commandRuns {
+			registered := commands[key.command]
+			if registered.RateLimit == nil || now.Sub(runs[len(runs)-1]) >= registered.RateLimit.Window {
+				delete(commandRuns, key)
+			}
+		}
+		commandsMu.RUnlock()
+		commandRunsSwept = now
+	}
+
+	key := commandRunKey{command: cmd.Name, userID: userID}
+	runs := commandRuns[key]
+	recent := runs[:0]
+	for _, run := range runs {
+		if now.Sub(run) < limit.Window {
+			recent = append(recent, run)
+		}
+	}
+	if len(recent) >= limit.Count {
+		commandRuns[key] = recent
+		return limit.Window - now.Sub(recent[0])
+	}
+	commandRuns[key] = append(recent, now)
+	return 0
+}
+
+// allowedCommands lists the commands userID may run in room, by name.
+func allowedCommands(room *models.Room, userID uint) []Command {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	allowed := make([]Command, 0, len(commands))
+	for _, cmd := range commands {
+		if cmd.Allowed == nil || cmd.Allowed(room, userID) {
+			allowed = append(allowed, cmd)
+		}
+	}
+	sort.Slice(allowed, func(i, j int) bool { return allowed[i].Name < allowed[j].Name })
+	return allowed
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kenzhe14/chat/models"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		content string
+		name    string
+		args    string
+		ok      bool
+	}{
+		{content: "/help", name: "help", ok: true},
+		{content: "/ME waves", name: "me", args: "waves", ok: true},
+		{content: "  /remind   30m  check deploy  ", name: "remind", args: "30m  check deploy", ok: true},
+		{content: "/topic\tNew topic", name: "topic", args: "New topic", ok: true},
+		{content: "/topic\nline one\nline two", name: "topic", args: "line one\nline two", ok: true},
+		{content: "//help", ok: false},
+		{content: " //not a command", ok: false},
+		{content: "/", ok: false},
+		{content: "/ help", ok: false},
+		{content: "hello /help", ok: false},
+		{content: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.content)
+		if ok != tt.ok || name != tt.name || args != tt.args {
+			t.Errorf("parseCommand(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.content, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestUnescapeCommand(t *testing.T) {
+	tests := map[string]string{
+		"//help":       "/help",
+		"  //shrug":    "/shrug",
+		"///":          "//",
+		"/help":        "/help",
+		"plain text":   "plain text",
+		"a // b":       "a // b",
+		"  indented":   "  indented",
+		"http://x.org": "http://x.org",
+	}
+	for content, want := range tests {
+		if got := unescapeCommand(content); got != want {
+			t.Errorf("unescapeCommand(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestParseCommandDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"30m", 30 * time.Minute, true},
+		{"2h", 2 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"3d", 72 * time.Hour, true},
+		{"45s", 45 * time.Second, true},
+		{"0m", 0, false},
+		{"-5m", 0, false},
+		{"0d", 0, false},
+		{"-1d", 0, false},
+		{"1.5d", 0, false},
+		{"d", 0, false},
+		{"10", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseCommandDuration(tt.value)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseCommandDuration(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBuiltinCommandsAreRegistered(t *testing.T) {
+	for _, name := range []string{"help", "me", "topic", "invite", "kick", "mute", "unmute", "remind"} {
+		commandsMu.RLock()
+		_, ok := commands[name]
+		commandsMu.RUnlock()
+		if !ok {
+			t.Errorf("/%s is not registered", name)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	run := func(ctx *CommandContext) *CommandReply { return ephemeral("ok") }
+
+	if err := RegisterCommand(Command{Name: "Test-Register", Run: run}); err != nil {
+		t.Fatalf("RegisterCommand: %v", err)
+	}
+	commandsMu.RLock()
+	_, ok := commands["test-register"]
+	commandsMu.RUnlock()
+	if !ok {
+		t.Error("command name was not lowercased")
+	}
+
+	invalid := []Command{
+		{Name: "test-register", Run: run},
+		{Name: "TEST-REGISTER", Run: run},
+		{Name: "help", Run: run},
+		{Name: "", Run: run},
+		{Name: "1abc", Run: run},
+		{Name: "has space", Run: run},
+		{Name: "/slash", Run: run},
+		{Name: strings.Repeat("a", 33), Run: run},
+		{Name: "test-no-run"},
+	}
+	for _, cmd := range invalid {
+		if err := RegisterCommand(cmd); err == nil {
+			t.Errorf("RegisterCommand(%q) succeeded, want an error", cmd.Name)
+		}
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	var got *CommandContext
+	err := RegisterCommand(Command{
+		Name: "test-run",
+		Run: func(ctx *CommandContext) *CommandReply {
+			got = ctx
+			return ephemeral("ran with %q", ctx.Args)
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterCommand: %v", err)
+	}
+	if err := RegisterCommand(Command{
+		Name:    "test-denied",
+		Allowed: func(room *models.Room, userID uint) bool { return false },
+		Run:     func(ctx *CommandContext) *CommandReply { t.Error("denied command ran"); return ephemeral("") },
+	}); err != nil {
+		t.Fatalf("RegisterCommand: %v", err)
+	}
+
+	room := &models.Room{ID: 1}
+	ctx := &CommandContext{Room: room, User: models.User{ID: 7}, Args: "a b", ClientMessageID: "c-1"}
+
+	reply := runCommand("test-run", ctx)
+	if reply.Command != "test-run" || reply.Ephemeral != `ran with "a b"` {
+		t.Errorf("runCommand = %+v", reply)
+	}
+	if got != ctx {
+		t.Error("command did not receive the context")
+	}
+
+	if reply := runCommand("test-denied", ctx); !strings.Contains(reply.Ephemeral, "нет прав") {
+		t.Errorf("denied command reply = %q", reply.Ephemeral)
+	}
+	if reply := runCommand("test-missing", ctx); reply.Command != "test-missing" || !strings.Contains(reply.Ephemeral, "/help") {
+		t.Errorf("unknown command reply = %+v", reply)
+	}
+}
+
+func TestCommandRateLimit(t *testing.T) {
+	cmd := Command{Name: "test-limited", RateLimit: &CommandRateLimit{Count: 2, Window: time.Minute}}
+	now := time.Now()
+
+	if wait := commandRateLimitWait(cmd, 1, now); wait != 0 {
+		t.Fatalf("first run waits %v", wait)
+	}
+	if wait := commandRateLimitWait(cmd, 1, now.Add(10*time.Second)); wait != 0 {
+		t.Fatalf("second run waits %v", wait)
+	}
+	if wait := commandRateLimitWait(cmd, 1, now.Add(20*time.Second)); wait != 40*time.Second {
+		t.Errorf("third run waits %v, want 40s", wait)
+	}
+	if wait := commandRateLimitWait(cmd, 2, now.Add(20*time.Second)); wait != 0 {
+		t.Errorf("another user waits %v", wait)
+	}
+	if wait := commandRateLimitWait(cmd, 1, now.Add(time.Minute)); wait != 0 {
+		t.Errorf("run after the first left the window waits %v", wait)
+	}
+
+	unlimited := Command{Name: "test-unlimited"}
+	for i := 0; i < 100; i++ {
+		if wait := commandRateLimitWait(unlimited, 1, now); wait != 0 {
+			t.Fatalf("unlimited command waits %v", wait)
+		}
+	}
+}
+
+func TestRateLimitedBuiltins(t *testing.T) {
+	for _, name := range []string{"topic", "remind"} {
+		commandsMu.RLock()
+		cmd := commands[name]
+		commandsMu.RUnlock()
+		if cmd.RateLimit == nil {
+			t.Errorf("/%s has no rate limit", name)
+		}
+	}
+}
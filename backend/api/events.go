@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Kenzhe14/chat/services"
+)
+
+// broadcastRoomEvent sends an event with the given type and payload to
+// every client connected to the room. A "type" key in the payload never
+// overrides the event type.
+func broadcastRoomEvent(manager *services.WebSocketManager, roomID uint, eventType string, payload map[string]interface{}) {
+	event := map[string]interface{}{
+		"room_id":   roomID,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	for k, v := range payload {
+		event[k] = v
+	}
+	event["type"] = eventType
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+	manager.BroadcastToRoom(roomID, data)
+}
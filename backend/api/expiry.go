@@ -0,0 +1,107 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"gorm.io/gorm"
+)
+
+const expiredSweepBatch = 500
+
+// RunExpiredMessageSweeper periodically hard-deletes disappearing messages
+// whose timer has run out, together with their replies and attachments,
+// and tells the rooms they are gone.
+func RunExpiredMessageSweeper(manager *services.WebSocketManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			if sweepExpiredMessages(manager) < expiredSweepBatch {
+				break
+			}
+		}
+	}
+}
+
+// sweepExpiredMessages deletes one batch of expired messages and returns
+// how many it removed.
+func sweepExpiredMessages(manager *services.WebSocketManager) int {
+	expiredIDs := db.DB.Unscoped().Model(&models.Message{}).Select("id").Where("expires_at <= NOW()")
+
+	var expired []models.Message
+	if err := db.DB.Unscoped().Select("id", "room_id", "parent_id").
+		Where("expires_at <= NOW() OR parent_id IN (?)", expiredIDs).
+		// Replies first, so a batch never strands them without their parent.
+		Order("parent_id IS NULL, id").
+		Limit(expiredSweepBatch).
+		Find(&expired).Error; err != nil {
+		log.Printf("Error finding expired messages: %v", err)
+		return 0
+	}
+	if len(expired) == 0 {
+		return 0
+	}
+
+	ids := make([]uint, len(expired))
+	for i, m := range expired {
+		ids[i] = m.ID
+	}
+
+	var blobKeys []string
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		blobKeys, err = hardDeleteMessages(tx, ids)
+		return err
+	}); err != nil {
+		log.Printf("Error deleting expired messages: %v", err)
+		return 0
+	}
+	deleteBlobs(blobKeys)
+
+	for _, m := range expired {
+		broadcastRoomEvent(manager, m.RoomID, "message_deleted", map[string]interface{}{
+			"message_id": m.ID,
+			"parent_id":  m.ParentID,
+			"expired":    true,
+		})
+	}
+	return len(expired)
+}
+
+// hardDeleteMessages removes messages and every record that refers to
+// them. It returns the storage keys of their attachments, to be deleted
+// once the transaction has committed.
+func hardDeleteMessages(tx *gorm.DB, ids []uint) ([]string, error) {
+	keys, err := deleteMessageAttachments(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, model := range []interface{}{
+		&models.MessageRevision{},
+		&models.MessageReaction{},
+		&models.MessageMention{},
+		&models.Notification{},
+		&models.PinnedMessage{},
+		&models.Bookmark{},
+	} {
+		if err := tx.Where("message_id IN ?", ids).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Exec("DELETE FROM message_link_previews WHERE message_id IN ?", ids).Error; err != nil {
+		return nil, err
+	}
+	if err := deleteMessagePolls(tx, ids); err != nil {
+		return nil, err
+	}
+	if err := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Message{}).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/media"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+	"github.com/Kenzhe14/chat/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RunMediaWorker consumes upload events from RabbitMQ and generates image
+// thumbnails, dimensions and blurhash placeholders. When the attachment is
+// already part of a message, the room receives a message_updated event.
+func RunMediaWorker(manager *services.WebSocketManager) error {
+	return services.ConsumeMediaJobs(func(job services.MediaJob) error {
+		if err := processAttachment(manager, job.AttachmentID); err != nil {
+			return fmt.Errorf("attachment %d: %w", job.AttachmentID, err)
+		}
+		return nil
+	})
+}
+
+func processAttachment(manager *services.WebSocketManager, attachmentID uint) error {
+	var attachment models.Attachment
+	err := db.DB.First(&attachment, attachmentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if attachment.ProcessedAt != nil || !media.Supported(attachment.MimeType) {
+		return nil
+	}
+
+	ctx := context.Background()
+	reader, err := storage.Store.Get(ctx, attachment.StorageKey)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := media.ProcessImage(reader)
+	reader.Close()
+
+	now := time.Now()
+	updates := map[string]interface{}{"processed_at": now}
+	var thumbnailKey string
+	if err != nil {
+		// Undecodable images keep working as plain downloads.
+		log.Printf("Error processing image attachment %d: %v", attachment.ID, err)
+	} else {
+		thumbnailKey = attachment.StorageKey + "_thumb.jpg"
+		if err := storage.Store.Put(ctx, thumbnailKey, bytes.NewReader(info.Thumbnail), int64(len(info.Thumbnail)), "image/jpeg"); err != nil {
+			return err
+		}
+		updates["width"] = info.Width
+		updates["height"] = info.Height
+		updates["thumbnail_key"] = thumbnailKey
+		updates["thumbnail_width"] = info.ThumbnailWidth
+		updates["thumbnail_height"] = info.ThumbnailHeight
+		updates["blurhash"] = info.Blurhash
+	}
+
+	// RETURNING picks up a message link made while we were processing, so
+	// either the link or this update sees the other's result.
+	var updated models.Attachment
+	result := db.DB.Model(&updated).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "message_id"}}}).
+		Where("id = ?", attachment.ID).
+		Updates(updates)
+	if result.Error != nil {
+		if thumbnailKey != "" {
+			deleteBlobs([]string{thumbnailKey})
+		}
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// The attachment was deleted in the meantime.
+		if thumbnailKey != "" {
+			deleteBlobs([]string{thumbnailKey})
+		}
+		return nil
+	}
+
+	if updated.MessageID == nil || thumbnailKey == "" {
+		return nil
+	}
+
+	var message models.Message
+	if err := withMessageContent(db.DB).First(&message, *updated.MessageID).Error; err != nil {
+		return err
+	}
+	if message.IsDeleted {
+		return nil
+	}
+
+	broadcastRoomEvent(manager, message.RoomID, "message_updated", map[string]interface{}{
+		"message_id": message.ID,
+		"parent_id":  message.ParentID,
+		"message":    message,
+	})
+	return nil
+}
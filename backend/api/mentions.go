@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"gorm.io/gorm"
+)
+
+const (
+	mentionHere = "here"
+	mentionRoom = "room"
+
+	notificationPreviewLength = 100
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]+)`)
+
+type parsedMentions struct {
+	Usernames []string
+	Here      bool
+	Room      bool
+}
+
+func (p parsedMentions) empty() bool {
+	return len(p.Usernames) == 0 && !p.Here && !p.Room
+}
+
+// parseMentions extracts @username, @here and @room mentions from content.
+func parseMentions(content string) parsedMentions {
+	var parsed parsedMentions
+	seen := make(map[string]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		switch name {
+		case mentionHere:
+			parsed.Here = true
+		case mentionRoom:
+			parsed.Room = true
+		default:
+			parsed.Usernames = append(parsed.Usernames, name)
+		}
+	}
+	return parsed
+}
+
+// roomMemberIDs returns the IDs of everyone who belongs to the room,
+// including its owner.
+func roomMemberIDs(room *models.Room) ([]uint, error) {
+	var ids []uint
+	if err := db.DB.Model(&models.RoomMember{}).Where("room_id = ?", room.ID).Pluck("user_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return append(ids, room.OwnerID), nil
+}
+
+// resolveMentions turns parsed mentions into the IDs of room members to
+// notify. Users outside the room and the author are never included.
+func resolveMentions(room *models.Room, authorID uint, parsed parsedMentions, online map[uint]bool) ([]uint, error) {
+	memberIDs, err := roomMemberIDs(room)
+	if err != nil {
+		return nil, err
+	}
+
+	isMember := make(map[uint]bool, len(memberIDs))
+	for _, id := range memberIDs {
+		isMember[id] = true
+	}
+
+	mentioned := make(map[uint]bool)
+	for id := range isMember {
+		if parsed.Room || (parsed.Here && online[id]) {
+			mentioned[id] = true
+		}
+	}
+
+	if len(parsed.Usernames) > 0 {
+		var ids []uint
+		if err := db.DB.Model(&models.User{}).
+			Where("LOWER(username) IN ?", parsed.Usernames).
+			Pluck("id", &ids).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if isMember[id] {
+				mentioned[id] = true
+			}
+		}
+	}
+
+	delete(mentioned, authorID)
+
+	result := make([]uint, 0, len(mentioned))
+	for id := range mentioned {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+func notificationPreview(content string) string {
+	runes := []rune(content)
+	if len(runes) <= notificationPreviewLength {
+		return content
+	}
+	return string(runes[:notificationPreviewLength]) + "…"
+}
+
+// mentionedUsers returns the room members the message's content mentions.
+func mentionedUsers(manager *services.WebSocketManager, message *models.Message, room *models.Room) ([]uint, error) {
+	parsed := parseMentions(message.Content)
+	if parsed.empty() {
+		return nil, nil
+	}
+	online, _ := manager.Presence(room.ID)
+	return resolveMentions(room, message.UserID, parsed, online)
+}
+
+// syncMentions makes userIDs the stored mentions of the message, removing
+// the ones its content no longer has, and notifies the users it did not
+// mention before. It returns the notifications to push once tx commits.
+func syncMentions(tx *gorm.DB, message *models.Message, room *models.Room, userIDs []uint) ([]models.Notification, error) {
+	var existing []uint
+	if err := tx.Model(&models.MessageMention{}).Where("message_id = ?", message.ID).
+		Pluck("user_id", &existing).Error; err != nil {
+		return nil, err
+	}
+
+	keep := make(map[uint]bool, len(userIDs))
+	for _, id := range userIDs {
+		keep[id] = true
+	}
+	had := make(map[uint]bool, len(existing))
+	var stale []uint
+	for _, id := range existing {
+		had[id] = true
+		if !keep[id] {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) > 0 {
+		if err := tx.Where("message_id = ? AND user_id IN ?", message.ID, stale).
+			Delete(&models.MessageMention{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		mentions      []models.MessageMention
+		notifications []models.Notification
+	)
+	for _, id := range userIDs {
+		if had[id] {
+			continue
+		}
+		mentions = append(mentions, models.MessageMention{MessageID: message.ID, UserID: id})
+		notifications = append(notifications, models.Notification{
+			UserID:    id,
+			Type:      models.NotificationTypeMention,
+			RoomID:    room.ID,
+			MessageID: message.ID,
+			ActorID:   message.UserID,
+			Preview:   notificationPreview(message.ContentText),
+			CreatedAt: time.Now(),
+		})
+	}
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	if err := tx.Create(&mentions).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// attachMentions fills in the users each of the given messages mentions.
+func attachMentions(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+	byID := make(map[uint]int, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+		byID[m.ID] = i
+		messages[i].Mentions = nil
+	}
+
+	var mentions []models.MessageMention
+	if err := db.DB.Where("message_id IN ?", ids).Order("message_id, user_id").Find(&mentions).Error; err != nil {
+		return err
+	}
+	for _, mention := range mentions {
+		i := byID[mention.MessageID]
+		messages[i].Mentions = append(messages[i].Mentions, mention.UserID)
+	}
+	return nil
+}
+
+// processMentions stores the users mentioned by a new message, creates their
+// notifications and pushes them to every connection of those users.
+func processMentions(manager *services.WebSocketManager, message *models.Message, room *models.Room, author models.User) error {
+	userIDs, err := mentionedUsers(manager, message, room)
+	if err != nil || len(userIDs) == 0 {
+		return err
+	}
+
+	var notifications []models.Notification
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		notifications, err = syncMentions(tx, message, room, userIDs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	message.Mentions = userIDs
+	sendMentionNotifications(manager, notifications, room, author)
+	return nil
+}
+
+// sendMentionNotifications pushes mention notifications to every
+// connection of the mentioned users.
+func sendMentionNotifications(manager *services.WebSocketManager, notifications []models.Notification, room *models.Room, author models.User) {
+	for _, n := range notifications {
+		n.Actor = author
+		event, err := json.Marshal(map[string]interface{}{
+			"type":         "notification",
+			"notification": n,
+			"room_id":      room.ID,
+			"room_name":    room.Name,
+			"timestamp":    n.CreatedAt.Format(time.RFC3339),
+		})
+		if err != nil {
+			continue
+		}
+		manager.SendToUser(n.UserID, event)
+	}
+}
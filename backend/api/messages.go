@@ -2,22 +2,186 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/markup"
 	"github.com/Kenzhe14/chat/models"
 	"github.com/Kenzhe14/chat/services"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+type UpdateMessageRequest struct {
+	Content string  `json:"content" binding:"required"`
+	Format  *string `json:"format" binding:"omitempty,oneof=plain markdown"`
+}
+
 type CreateMessageRequest struct {
-	Content string `json:"content" binding:"required"`
-	RoomID  uint   `json:"room_id" binding:"required"`
+	Content       string `json:"content"`
+	Format        string `json:"format" binding:"omitempty,oneof=plain markdown"`
+	RoomID        uint   `json:"room_id" binding:"required"`
+	ParentID      *uint  `json:"parent_id"`
+	AttachmentIDs []uint `json:"attachment_ids"`
+	TTLSeconds    *int   `json:"ttl_seconds" binding:"omitempty,min=1,max=31536000"`
+	// Poll makes the message a poll whose question is the content.
+	Poll *CreatePollRequest `json:"poll"`
+	// ClientMessageID is generated by the client for each message it
+	// sends; retries with the same ID return the stored message.
+	ClientMessageID string `json:"client_message_id" binding:"omitempty,max=64"`
+}
+
+// maxMessageLength caps message content, in characters. Longer input is
+// rejected before it reaches the Markdown renderer or a command.
+const maxMessageLength = 4000
+
+func messageTooLong(content string) bool {
+	return utf8.RuneCountInString(content) > maxMessageLength
+}
+
+func respondMessageTooLong(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение слишком длинное", "max_length": maxMessageLength})
+}
+
+// messageUndoWindow is how long authors can restore a message they deleted.
+const messageUndoWindow = time.Minute
+
+// abandonedUploadAge is how long an upload may stay unattached to a message.
+const abandonedUploadAge = 24 * time.Hour
+
+// unexpiredMessages filters out disappearing messages past their expiry,
+// which the sweeper may not have removed yet.
+const unexpiredMessages = "(expires_at IS NULL OR expires_at > NOW())"
+
+// messageExpiry returns when a new message in room should disappear. A
+// per-message TTL can shorten the room's timer but never extend it.
+func messageExpiry(room *models.Room, ttlSeconds *int, now time.Time) *time.Time {
+	ttl := room.MessageTTLSeconds
+	if ttlSeconds != nil && (ttl == 0 || *ttlSeconds < ttl) {
+		ttl = *ttlSeconds
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(time.Duration(ttl) * time.Second)
+	return &expiresAt
+}
+
+// renderMessageContent fills the HTML and plain-text forms of a message from
+// its content and format. Plain messages have no HTML form.
+func renderMessageContent(message *models.Message) {
+	if message.Format == models.MessageFormatMarkdown {
+		message.ContentHTML, message.ContentText = markup.Render(message.Content)
+		return
+	}
+	message.Format = models.MessageFormatPlain
+	message.ContentHTML = ""
+	message.ContentText = message.Content
+}
+
+// withMessageContent preloads everything rendered with a message: its
+// author, attachments, link previews and poll.
+func withMessageContent(query *gorm.DB) *gorm.DB {
+	return query.Preload("User").Preload("Attachments").Preload("LinkPreviews").
+		Preload("Poll.Options", func(q *gorm.DB) *gorm.DB {
+			return q.Order("position")
+		})
+}
+
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 100
+	defaultThreadLimit  = 50
+	maxThreadLimit      = 100
+)
+
+// attachThreadSummaries fills in reply counts and last-reply metadata for
+// the given top-level messages.
+func attachThreadSummaries(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+
+	var summaries []struct {
+		ParentID        uint
+		ReplyCount      int
+		LastReplyAt     time.Time
+		LastReplyUserID uint
+	}
+	query := `
+		SELECT DISTINCT ON (parent_id) parent_id, user_id AS last_reply_user_id, created_at AS last_reply_at,
+			COUNT(*) OVER (PARTITION BY parent_id) AS reply_count
+		FROM messages
+		WHERE parent_id IN ? AND deleted_at IS NULL AND removed_at IS NULL AND ` + unexpiredMessages + `
+		ORDER BY parent_id, created_at DESC, id DESC
+	`
+	if err := db.DB.Raw(query, ids).Scan(&summaries).Error; err != nil {
+		return err
+	}
+
+	byParent := make(map[uint]int, len(messages))
+	for i, m := range messages {
+		byParent[m.ID] = i
+	}
+	for _, s := range summaries {
+		i := byParent[s.ParentID]
+		lastReplyAt := s.LastReplyAt
+		lastReplyUserID := s.LastReplyUserID
+		messages[i].ReplyCount = s.ReplyCount
+		messages[i].LastReplyAt = &lastReplyAt
+		messages[i].LastReplyUserID = &lastReplyUserID
+	}
+	return nil
+}
+
+// roomTimeline selects the top-level messages of a room.
+func roomTimeline(roomID uint) *gorm.DB {
+	return db.DB.Model(&models.Message{}).Where("room_id = ? AND parent_id IS NULL", roomID).Where(unexpiredMessages)
 }
 
+// fetchMessagePage loads up to limit messages from query in the given ID
+// order and reports whether more messages exist beyond them. A zero limit
+// still fetches the extra row, so it only reports whether any exist.
+func fetchMessagePage(query *gorm.DB, order string, limit int) ([]models.Message, bool, error) {
+	var messages []models.Message
+	if err := withMessageContent(query.Order("id " + order).Limit(limit + 1)).Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, hasMore, nil
+}
+
+func timelineHasMessages(roomID uint, condition string, id uint64) (bool, error) {
+	var count int64
+	err := roomTimeline(roomID).Where(condition, id).Count(&count).Error
+	return count > 0, err
+}
+
+// GetMessages returns a page of a room's top-level messages in ID order.
+// Without a cursor it returns the latest messages; "before" and "after" page
+// backwards and forwards from a message ID, and "around" centres the page on
+// a message so the client can jump to it.
 func GetMessages(c *gin.Context) {
 	roomID, err := strconv.ParseUint(c.Param("room_id"), 10, 32)
 	if err != nil {
@@ -25,84 +189,916 @@ func GetMessages(c *gin.Context) {
 		return
 	}
 
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultMessageLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxMessageLimit {
+		limit = maxMessageLimit
+	}
+
+	cursors := 0
+	var cursorName string
+	var cursorID uint64
+	for _, name := range []string{"before", "after", "around"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		cursors++
+		cursorName, cursorID = name, id
+	}
+	if cursors > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите только один из параметров before, after или around"})
+		return
+	}
+
 	var room models.Room
-	if err := db.DB.First(&room, roomID).Error; err != nil {
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
 
-	var messages []models.Message
-	result := db.DB.Where("room_id = ?", roomID).
-		Order("created_at").
-		Preload("User").
-		Find(&messages)
+	if !canViewRoom(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return
+	}
 
-	if result.Error != nil {
+	rid := room.ID
+	var messages, newer []models.Message
+	var hasMoreBefore, hasMoreAfter bool
+
+	switch cursorName {
+	case "":
+		messages, hasMoreBefore, err = fetchMessagePage(roomTimeline(rid), "DESC", limit)
+	case "before":
+		messages, hasMoreBefore, err = fetchMessagePage(roomTimeline(rid).Where("id < ?", cursorID), "DESC", limit)
+		if err == nil {
+			hasMoreAfter, err = timelineHasMessages(rid, "id >= ?", cursorID)
+		}
+	case "after":
+		messages, hasMoreAfter, err = fetchMessagePage(roomTimeline(rid).Where("id > ?", cursorID), "ASC", limit)
+		if err == nil {
+			hasMoreBefore, err = timelineHasMessages(rid, "id <= ?", cursorID)
+		}
+	case "around":
+		messages, hasMoreBefore, err = fetchMessagePage(roomTimeline(rid).Where("id < ?", cursorID), "DESC", limit/2)
+		if err == nil {
+			newer, hasMoreAfter, err = fetchMessagePage(roomTimeline(rid).Where("id >= ?", cursorID), "ASC", limit-limit/2)
+			messages = append(messages, newer...)
+		}
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении сообщений"})
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	if err := attachThreadSummaries(messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении ответов"})
+		return
+	}
+
+	if err := attachReactions(messages, c.GetUint("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении реакций"})
+		return
+	}
+
+	if err := attachMentions(messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении упоминаний"})
+		return
+	}
+
+	if err := attachPollResults(messages, c.GetUint("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении результатов опроса"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":        messages,
+		"has_more_before": hasMoreBefore,
+		"has_more_after":  hasMoreAfter,
+	})
+}
+
+// broadcastThreadReply notifies the room about a new reply together with the
+// parent's updated thread summary, so open threads and reply counters update live.
+func broadcastThreadReply(manager *services.WebSocketManager, reply *models.Message) {
+	parents := []models.Message{{ID: *reply.ParentID}}
+	if err := attachThreadSummaries(parents); err != nil {
+		log.Printf("Error loading thread summary for message %d: %v", *reply.ParentID, err)
+	}
+
+	broadcastRoomEvent(manager, reply.RoomID, "thread_reply", map[string]interface{}{
+		"parent_id":          *reply.ParentID,
+		"message":            reply,
+		"reply_count":        parents[0].ReplyCount,
+		"last_reply_at":      parents[0].LastReplyAt,
+		"last_reply_user_id": parents[0].LastReplyUserID,
+	})
 }
 
-func CreateMessage(c *gin.Context) {
-	var req CreateMessageRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// GetThread returns a top-level message with a page of its replies, oldest
+// first. Pass the last reply ID as "after" to load the next page.
+func GetThread(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
 		return
 	}
 
-	userID := c.GetUint("user_id")
-	if userID == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultThreadLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxThreadLimit {
+		limit = maxThreadLimit
+	}
+
+	after, err := strconv.ParseUint(c.DefaultQuery("after", "0"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+		return
+	}
+
+	var parent models.Message
+	if err := withMessageContent(db.DB).First(&parent, messageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return
+	}
+
+	if parent.ParentID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение является ответом в ветке"})
 		return
 	}
 
 	var room models.Room
-	if err := db.DB.First(&room, req.RoomID).Error; err != nil {
+	if err := workspaceRooms(c).First(&room, parent.RoomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
 
-	var user models.User
-	if err := db.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Пользователь не найден"})
+	if !canViewRoom(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
 		return
 	}
 
-	message := models.Message{
-		Content:   req.Content,
-		UserID:    userID,
-		RoomID:    req.RoomID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	var replies []models.Message
+	if err := withMessageContent(db.DB.Where("parent_id = ? AND id > ?", parent.ID, after).
+		Where(unexpiredMessages).
+		Order("id").
+		Limit(limit + 1)).
+		Find(&replies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении ответов"})
+		return
 	}
 
-	if err := db.DB.Create(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании сообщения"})
+	hasMore := len(replies) > limit
+	if hasMore {
+		replies = replies[:limit]
+	}
+
+	parents := []models.Message{parent}
+	if err := attachThreadSummaries(parents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении ответов"})
 		return
 	}
 
-	db.DB.Model(&message).Association("User").Find(&message.User)
+	userID := c.GetUint("user_id")
+	if err := attachReactions(parents, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении реакций"})
+		return
+	}
+	if err := attachReactions(replies, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении реакций"})
+		return
+	}
+	if err := attachPollResults(parents, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении результатов опроса"})
+		return
+	}
+	if err := attachPollResults(replies, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении результатов опроса"})
+		return
+	}
+	if err := attachMentions(parents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении упоминаний"})
+		return
+	}
+	if err := attachMentions(replies); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении упоминаний"})
+		return
+	}
 
-	go func() {
-		messageEvent := services.MessageEvent{
-			Type:      "new_message",
-			RoomID:    req.RoomID,
-			UserID:    userID,
-			Username:  user.Username,
+	c.JSON(http.StatusOK, gin.H{
+		"parent":   parents[0],
+		"replies":  replies,
+		"has_more": hasMore,
+	})
+}
+
+func CreateMessage(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if messageTooLong(req.Content) {
+			respondMessageTooLong(c)
+			return
+		}
+
+		userID := c.GetUint("user_id")
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return
+		}
+
+		var room models.Room
+		if err := workspaceRooms(c).First(&room, req.RoomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+			return
+		}
+
+		var user models.User
+		if err := db.DB.First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Пользователь не найден"})
+			return
+		}
+
+		if req.ClientMessageID != "" {
+			existing, err := findClientMessage(userID, req.ClientMessageID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании сообщения"})
+				return
+			}
+			if existing != nil {
+				respondClientMessage(c, existing, req.RoomID)
+				return
+			}
+		}
+
+		// Slash commands run instead of being posted; each checks its own
+		// permissions.
+		if req.Poll == nil {
+			if name, args, ok := parseCommand(req.Content); ok {
+				c.JSON(http.StatusOK, runCommand(name, &CommandContext{
+					Manager:         manager,
+					Room:            &room,
+					User:            user,
+					Args:            args,
+					ParentID:        req.ParentID,
+					ClientMessageID: req.ClientMessageID,
+				}))
+				return
+			}
+			req.Content = unescapeCommand(req.Content)
+		}
+
+		if strings.TrimSpace(req.Content) == "" && len(req.AttachmentIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение не может быть пустым"})
+			return
+		}
+
+		if len(req.AttachmentIDs) > maxAttachmentsPerMessage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Слишком много вложений", "max_attachments": maxAttachmentsPerMessage})
+			return
+		}
+
+		var poll *models.Poll
+		if req.Poll != nil {
+			if strings.TrimSpace(req.Content) == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите вопрос опроса"})
+				return
+			}
+			var err error
+			if poll, err = newPoll(req.Poll, time.Now()); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if req.ParentID != nil {
+			var parent models.Message
+			if err := db.DB.First(&parent, *req.ParentID).Error; err != nil || parent.RoomID != req.RoomID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Родительское сообщение не найдено"})
+				return
+			}
+			if parent.ParentID != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя ответить на ответ в ветке"})
+				return
+			}
+		}
+
+		if perr := checkCanPost(&room, userID); perr != nil {
+			respondPostingError(c, perr)
+			return
+		}
+
+		message := models.Message{
 			Content:   req.Content,
-			Timestamp: message.CreatedAt.Format(time.RFC3339),
-			Data: map[string]interface{}{
-				"message_id": message.ID,
-			},
+			Format:    req.Format,
+			UserID:    userID,
+			RoomID:    req.RoomID,
+			ParentID:  req.ParentID,
+			Poll:      poll,
+			ExpiresAt: messageExpiry(&room, req.TTLSeconds, time.Now()),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
 		}
+		if poll != nil {
+			message.Type = models.MessageTypePoll
+		}
+		if req.ClientMessageID != "" {
+			message.ClientMessageID = &req.ClientMessageID
+		}
+
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := lockSlowMode(tx, &room, userID); err != nil {
+				return err
+			}
+			return insertMessage(tx, &message, req.AttachmentIDs)
+		})
+		var perr *PostingError
+		if errors.As(err, &perr) {
+			respondPostingError(c, perr)
+			return
+		}
+		if err == errInvalidAttachments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные вложения"})
+			return
+		}
+		if err != nil && req.ClientMessageID != "" {
+			// A concurrent retry may have stored the message first.
+			if existing, findErr := findClientMessage(userID, req.ClientMessageID); findErr == nil && existing != nil {
+				respondClientMessage(c, existing, req.RoomID)
+				return
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании сообщения"})
+			return
+		}
+
+		announceMessage(manager, &message, &room, user)
+
+		c.JSON(http.StatusCreated, message)
+	}
+}
+
+// findClientMessage returns the message userID already sent with the given
+// client message ID, or nil if there is none.
+func findClientMessage(userID uint, clientMessageID string) (*models.Message, error) {
+	var message models.Message
+	err := withMessageContent(db.DB.Unscoped()).
+		Where("user_id = ? AND client_message_id = ?", userID, clientMessageID).
+		First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// respondClientMessage answers a retried send with the message stored by
+// the first attempt.
+func respondClientMessage(c *gin.Context, message *models.Message, roomID uint) {
+	if message.RoomID != roomID {
+		c.JSON(http.StatusConflict, gin.H{"error": "Идентификатор сообщения уже использован"})
+		return
+	}
+	messages := []models.Message{*message}
+	if err := attachReactions(messages, message.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении реакций"})
+		return
+	}
+	if err := attachPollResults(messages, message.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении результатов опроса"})
+		return
+	}
+	if err := attachMentions(messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении упоминаний"})
+		return
+	}
+	c.JSON(http.StatusOK, messages[0])
+}
+
+// insertMessage renders and stores a new message and links its pending
+// attachments. It is the persistence half of posting a message; callers
+// run announceMessage once the transaction has committed.
+func insertMessage(tx *gorm.DB, message *models.Message, attachmentIDs []uint) error {
+	renderMessageContent(message)
+	if message.Type == "" {
+		message.Type = models.MessageTypeText
+	}
+	if err := tx.Create(message).Error; err != nil {
+		return err
+	}
+	if err := linkAttachments(tx, message, attachmentIDs); err != nil {
+		return err
+	}
+	if message.Attachments == nil {
+		message.Attachments = []models.Attachment{}
+	}
+	message.LinkPreviews = []models.LinkPreview{}
+	return nil
+}
 
+// announceMessage runs the side effects of a newly stored message: mention
+// notifications, link unfurling, the RabbitMQ event and the room broadcast.
+func announceMessage(manager *services.WebSocketManager, message *models.Message, room *models.Room, user models.User) {
+	message.User = user
+
+	if err := processMentions(manager, message, room, user); err != nil {
+		log.Printf("Error processing mentions for message %d: %v", message.ID, err)
+	}
+
+	queueUnfurl(message)
+
+	attachmentIDs := make([]uint, len(message.Attachments))
+	for i, a := range message.Attachments {
+		attachmentIDs[i] = a.ID
+	}
+	messageEvent := services.MessageEvent{
+		Type:      "new_message",
+		RoomID:    message.RoomID,
+		UserID:    message.UserID,
+		Username:  user.Username,
+		Content:   message.Content,
+		Timestamp: message.CreatedAt.Format(time.RFC3339),
+		Data: map[string]interface{}{
+			"message_id":        message.ID,
+			"parent_id":         message.ParentID,
+			"attachment_ids":    attachmentIDs,
+			"client_message_id": message.ClientMessageID,
+		},
+	}
+	messageID := message.ID
+	go func() {
 		if err := services.PublishMessage(messageEvent); err != nil {
+			log.Printf("Error publishing message %d: %v", messageID, err)
 		}
 	}()
 
-	c.JSON(http.StatusCreated, message)
+	if message.ParentID != nil {
+		broadcastThreadReply(manager, message)
+	} else {
+		broadcastNewMessage(manager, message)
+	}
+}
+
+// broadcastNewMessage sends a top-level message to the room as a
+// new_message event carrying the message's own fields. The message's type
+// is sent as message_type, since "type" names the event.
+func broadcastNewMessage(manager *services.WebSocketManager, message *models.Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding message %d: %v", message.ID, err)
+		return
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		log.Printf("Error encoding message %d: %v", message.ID, err)
+		return
+	}
+	payload["message_type"] = message.Type
+	delete(payload, "type")
+	broadcastRoomEvent(manager, message.RoomID, "new_message", payload)
+}
+
+// UpdateMessage lets the author change a message within the room's edit
+// window. The previous content is kept in the revision history.
+func UpdateMessage(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+			return
+		}
+
+		var req UpdateMessageRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if messageTooLong(req.Content) {
+			respondMessageTooLong(c)
+			return
+		}
+
+		userID := c.GetUint("user_id")
+
+		var message models.Message
+		if err := withMessageContent(db.DB).First(&message, messageID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+			return
+		}
+
+		var room models.Room
+		if err := workspaceRooms(c).First(&room, message.RoomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+			return
+		}
+
+		if message.UserID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Можно редактировать только свои сообщения"})
+			return
+		}
+
+		if message.IsDeleted {
+			c.JSON(http.StatusGone, gin.H{"error": "Сообщение удалено"})
+			return
+		}
+
+		if room.EditWindowSeconds > 0 && time.Since(message.CreatedAt) > time.Duration(room.EditWindowSeconds)*time.Second {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Время редактирования сообщения истекло"})
+			return
+		}
+
+		format := message.Format
+		if req.Format != nil {
+			format = *req.Format
+		}
+
+		if req.Content == message.Content && format == message.Format {
+			c.JSON(http.StatusOK, message)
+			return
+		}
+
+		now := time.Now()
+		revision := models.MessageRevision{
+			MessageID: message.ID,
+			Content:   message.Content,
+			Format:    message.Format,
+			EditedBy:  userID,
+			CreatedAt: now,
+		}
+
+		message.Content = req.Content
+		message.Format = format
+		renderMessageContent(&message)
+
+		mentions, err := mentionedUsers(manager, &message, &room)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при редактировании сообщения"})
+			return
+		}
+
+		var notifications []models.Notification
+		err = db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&revision).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&message).Updates(map[string]interface{}{
+				"content":      message.Content,
+				"format":       message.Format,
+				"content_html": message.ContentHTML,
+				"content_text": message.ContentText,
+				"is_edited":    true,
+				"edited_at":    now,
+				"updated_at":   now,
+			}).Error; err != nil {
+				return err
+			}
+			notifications, err = syncMentions(tx, &message, &room, mentions)
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при редактировании сообщения"})
+			return
+		}
+
+		message.IsEdited = true
+		message.EditedAt = &now
+		message.UpdatedAt = now
+		message.Mentions = mentions
+
+		if len(notifications) > 0 {
+			var author models.User
+			if err := db.DB.First(&author, userID).Error; err == nil {
+				sendMentionNotifications(manager, notifications, &room, author)
+			}
+		}
+
+		broadcastRoomEvent(manager, message.RoomID, "message_edited", map[string]interface{}{
+			"message_id": message.ID,
+			"parent_id":  message.ParentID,
+			"content":    message.Content,
+			"edited_at":  now.Format(time.RFC3339),
+			"message":    message,
+		})
+
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+// GetMessageHistory lists the previous versions of a message, newest first.
+func GetMessageHistory(c *gin.Context) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return
+	}
+
+	var message models.Message
+	if err := db.DB.First(&message, messageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, message.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return
+	}
+
+	if !canViewRoom(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return
+	}
+
+	if message.IsDeleted {
+		c.JSON(http.StatusGone, gin.H{"error": "Сообщение удалено"})
+		return
+	}
+
+	var revisions []models.MessageRevision
+	if err := db.DB.Where("message_id = ?", message.ID).Order("id DESC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении истории изменений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+// DeleteMessage turns a message into a tombstone. Authors can restore their
+// own messages during the undo window, after which the content is purged;
+// messages removed by moderators are purged immediately.
+func DeleteMessage(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+			return
+		}
+
+		userID := c.GetUint("user_id")
+
+		var message models.Message
+		if err := db.DB.First(&message, messageID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+			return
+		}
+
+		var room models.Room
+		if err := workspaceRooms(c).First(&room, message.RoomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+			return
+		}
+
+		isAuthor := message.UserID == userID
+		if !isAuthor && !isRoomModerator(&room, userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет прав на удаление этого сообщения"})
+			return
+		}
+
+		if message.IsDeleted {
+			c.JSON(http.StatusGone, gin.H{"error": "Сообщение уже удалено"})
+			return
+		}
+
+		now := time.Now()
+		var blobKeys []string
+		err = db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&message).Updates(map[string]interface{}{
+				"removed_at": now,
+				"removed_by": userID,
+			}).Error; err != nil {
+				return err
+			}
+			if isAuthor {
+				return nil
+			}
+			var err error
+			blobKeys, err = purgeMessageContent(tx, []uint{message.ID})
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении сообщения"})
+			return
+		}
+		deleteBlobs(blobKeys)
+
+		broadcastRoomEvent(manager, message.RoomID, "message_deleted", map[string]interface{}{
+			"message_id": message.ID,
+			"parent_id":  message.ParentID,
+			"deleted_by": userID,
+		})
+
+		response := gin.H{"message": "Сообщение удалено", "message_id": message.ID}
+		if isAuthor {
+			response["undo_until"] = now.Add(messageUndoWindow).Format(time.RFC3339)
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// RestoreMessage undoes an author's own deletion within the undo window.
+func RestoreMessage(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+			return
+		}
+
+		userID := c.GetUint("user_id")
+
+		var message models.Message
+		if err := db.DB.First(&message, messageID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+			return
+		}
+
+		if err := workspaceRooms(c).First(&models.Room{}, message.RoomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+			return
+		}
+
+		if !message.IsDeleted {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение не удалено"})
+			return
+		}
+
+		if message.UserID != userID || message.RemovedBy == nil || *message.RemovedBy != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Восстановить можно только собственное удаленное сообщение"})
+			return
+		}
+
+		if time.Since(*message.RemovedAt) > messageUndoWindow {
+			c.JSON(http.StatusGone, gin.H{"error": "Время на отмену удаления истекло"})
+			return
+		}
+
+		result := db.DB.Model(&models.Message{}).
+			Where("id = ? AND removed_at IS NOT NULL AND purged_at IS NULL", message.ID).
+			Updates(map[string]interface{}{"removed_at": nil, "removed_by": nil})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при восстановлении сообщения"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusGone, gin.H{"error": "Время на отмену удаления истекло"})
+			return
+		}
+
+		if err := withMessageContent(db.DB).First(&message, message.ID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при восстановлении сообщения"})
+			return
+		}
+
+		broadcastRoomEvent(manager, message.RoomID, "message_restored", map[string]interface{}{
+			"message_id": message.ID,
+			"parent_id":  message.ParentID,
+			"message":    message,
+		})
+
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+// purgeMessageContent erases the content, edit history, mentions,
+// notifications and attachments of deleted messages. It returns the storage keys of the removed attachments,
+// to be deleted once the transaction has committed.
+func purgeMessageContent(tx *gorm.DB, ids []uint) ([]string, error) {
+	if err := tx.Model(&models.Message{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"content":      "",
+		"content_html": "",
+		"content_text": "",
+		"purged_at":    time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+	for _, model := range []interface{}{
+		&models.MessageRevision{},
+		&models.MessageMention{},
+		&models.Notification{},
+	} {
+		if err := tx.Where("message_id IN ?", ids).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Exec("DELETE FROM message_link_previews WHERE message_id IN ?", ids).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("message_id IN ?", ids).Delete(&models.PinnedMessage{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("message_id IN ?", ids).Delete(&models.Bookmark{}).Error; err != nil {
+		return nil, err
+	}
+	if err := deleteMessagePolls(tx, ids); err != nil {
+		return nil, err
+	}
+	return deleteMessageAttachments(tx, ids)
+}
+
+// RunMessagePurger periodically purges the content of messages whose undo
+// window has passed and removes uploads that were never sent.
+func RunMessagePurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purgeDeletedMessages()
+		purgeAbandonedUploads()
+	}
+}
+
+func purgeDeletedMessages() {
+	var ids []uint
+	err := db.DB.Model(&models.Message{}).
+		Where("removed_at IS NOT NULL AND removed_at < ? AND purged_at IS NULL", time.Now().Add(-messageUndoWindow)).
+		Pluck("id", &ids).Error
+	if err != nil {
+		log.Printf("Error finding deleted messages to purge: %v", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var blobKeys []string
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		blobKeys, err = purgeMessageContent(tx, ids)
+		return err
+	}); err != nil {
+		log.Printf("Error purging deleted messages: %v", err)
+		return
+	}
+	deleteBlobs(blobKeys)
+	log.Printf("Purged content of %d deleted messages", len(ids))
+}
+
+func purgeAbandonedUploads() {
+	var attachments []models.Attachment
+	cutoff := time.Now().Add(-abandonedUploadAge)
+	if err := db.DB.Where("message_id IS NULL AND created_at < ?", cutoff).Find(&attachments).Error; err != nil {
+		log.Printf("Error finding abandoned uploads: %v", err)
+		return
+	}
+	if len(attachments) == 0 {
+		return
+	}
+
+	ids := make([]uint, len(attachments))
+	var keys []string
+	for i, a := range attachments {
+		ids[i] = a.ID
+		keys = append(keys, a.BlobKeys()...)
+	}
+	if err := db.DB.Where("id IN ? AND message_id IS NULL", ids).Delete(&models.Attachment{}).Error; err != nil {
+		log.Printf("Error deleting abandoned uploads: %v", err)
+		return
+	}
+	deleteBlobs(keys)
+	log.Printf("Removed %d abandoned uploads", len(ids))
+}
+
+// WebSocketMessageFilter drops everything clients send over the WebSocket
+// once typing events have been handled. Chat messages are posted through
+// CreateMessage and reach the room as server-built new_message events, so
+// clients cannot forge messages or server events for other members.
+func WebSocketMessageFilter(manager *services.WebSocketManager) services.MessageFilter {
+	return func(client *services.Client, message []byte) bool {
+		return false
+	}
+}
+
+// CanTypeInRoom is the WebSocket typing guard: only users who may post in
+// the room, i.e. are neither muted nor in a read-only room, show as typing.
+func CanTypeInRoom(client *services.Client) bool {
+	var room models.Room
+	if err := db.DB.First(&room, client.RoomID).Error; err != nil {
+		return false
+	}
+	return checkPostingRules(&room, client.ID, false) == nil
 }
 
 func HandleWebSocket(manager *services.WebSocketManager) gin.HandlerFunc {
@@ -127,7 +1123,7 @@ func HandleWebSocket(manager *services.WebSocketManager) gin.HandlerFunc {
 		}
 
 		var room models.Room
-		if err := db.DB.First(&room, roomID).Error; err != nil {
+		if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 			return
 		}
@@ -41,6 +41,62 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// getWorkspaceRole returns the role userID holds in the workspace, or an
+// empty string if the user is not a member. Every user belongs to the
+// default workspace.
+func getWorkspaceRole(workspaceID, userID uint) string {
+	var member models.WorkspaceMember
+	if err := db.DB.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&member).Error; err == nil {
+		return member.Role
+	}
+	if workspaceID == db.DefaultWorkspaceID {
+		return models.WorkspaceRoleMember
+	}
+	return ""
+}
+
+// resolveWorkspace validates the requested workspace ID for userID. An empty
+// ID selects the default workspace.
+func resolveWorkspace(workspaceIDStr string, userID uint) (uint, string, int, string) {
+	workspaceID := uint64(db.DefaultWorkspaceID)
+	if workspaceIDStr != "" {
+		var err error
+		workspaceID, err = strconv.ParseUint(workspaceIDStr, 10, 32)
+		if err != nil {
+			return 0, "", http.StatusBadRequest, "Неверный ID рабочего пространства"
+		}
+	}
+
+	var workspace models.Workspace
+	if err := db.DB.First(&workspace, workspaceID).Error; err != nil {
+		return 0, "", http.StatusNotFound, "Рабочее пространство не найдено"
+	}
+
+	role := getWorkspaceRole(workspace.ID, userID)
+	if role == "" {
+		return 0, "", http.StatusForbidden, "Вы не являетесь участником этого рабочего пространства"
+	}
+	return workspace.ID, role, 0, ""
+}
+
+// WorkspaceMiddleware selects the workspace named by the X-Workspace-ID header
+// and checks that the authenticated user belongs to it.
+func WorkspaceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceID, role, status, message := resolveWorkspace(c.GetHeader("X-Workspace-ID"), c.GetUint("user_id"))
+		if status != 0 {
+			c.JSON(status, gin.H{"error": message})
+			c.Abort()
+			return
+		}
+
+		c.Set("workspace_id", workspaceID)
+		c.Set("workspace_role", role)
+
+		c.Next()
+	}
+}
+
 // WebSocketAuthMiddleware authenticates WebSocket connections using query parameters
 func WebSocketAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -81,9 +137,19 @@ func WebSocketAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		workspaceID, role, status, message := resolveWorkspace(c.Query("workspace_id"), user.ID)
+		if status != 0 {
+			log.Printf("WebSocket Auth: Workspace rejected for user %d: %s", user.ID, message)
+			c.JSON(status, gin.H{"error": message})
+			c.Abort()
+			return
+		}
+
 		log.Printf("WebSocket Auth: Successfully authenticated user: %s (ID: %d)", user.Username, user.ID)
 		c.Set("user_id", uint(userID))
 		c.Set("username", user.Username)
+		c.Set("workspace_id", workspaceID)
+		c.Set("workspace_role", role)
 
 		c.Next()
 	}
@@ -96,7 +162,7 @@ func CORSMiddleware() gin.HandlerFunc {
 
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-User-ID, Accept, Origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-User-ID, X-Workspace-ID, Accept, Origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 100
+)
+
+// GetNotifications lists the caller's notifications, newest first. Pass
+// unread=true to skip read ones and the last ID as "before" to page.
+func GetNotifications(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultNotificationLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxNotificationLimit {
+		limit = maxNotificationLimit
+	}
+
+	query := db.DB.Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	if before := c.Query("before"); before != "" {
+		beforeID, err := strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("id DESC").Limit(limit + 1).Preload("Actor").Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении уведомлений"})
+		return
+	}
+
+	hasMore := len(notifications) > limit
+	if hasMore {
+		notifications = notifications[:limit]
+	}
+
+	var unread int64
+	if err := db.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&unread).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении уведомлений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"unread_count":  unread,
+		"has_more":      hasMore,
+	})
+}
+
+func MarkNotificationRead(c *gin.Context) {
+	notificationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID уведомления"})
+		return
+	}
+
+	result := db.DB.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", notificationID, c.GetUint("user_id")).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении уведомления"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Уведомление прочитано"})
+}
+
+func MarkAllNotificationsRead(c *gin.Context) {
+	result := db.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", c.GetUint("user_id")).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении уведомлений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Все уведомления прочитаны", "updated": result.RowsAffected})
+}
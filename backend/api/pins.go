@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxPinnedMessages caps the number of pins in one room.
+const maxPinnedMessages = 50
+
+var errPinLimit = errors.New("pin limit reached")
+
+// loadPinnableMessage loads the message from the :id parameter and checks
+// that the caller moderates its room. It writes the error response itself.
+func loadPinnableMessage(c *gin.Context) (models.Message, bool) {
+	var message models.Message
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return message, false
+	}
+
+	if err := db.DB.First(&message, messageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return message, false
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, message.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return message, false
+	}
+
+	if !isRoomModerator(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Закреплять сообщения могут только модераторы"})
+		return message, false
+	}
+
+	return message, true
+}
+
+// GetRoomPins lists a room's pinned messages, most recently pinned first,
+// with who pinned them and when.
+func GetRoomPins(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+		return
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return
+	}
+
+	if !canViewRoom(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return
+	}
+
+	var pins []models.PinnedMessage
+	err = livePins(db.DB, room.ID).
+		Preload("Pinner").
+		Preload("Message", withMessageContent).
+		Order("pinned_messages.pinned_at DESC").
+		Find(&pins).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении закрепленных сообщений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pins":      pins,
+		"max_pins":  maxPinnedMessages,
+		"pin_count": len(pins),
+	})
+}
+
+// livePins selects the room's pins whose message is still shown. Pins of
+// deleted, removed and expired messages do not count toward the cap.
+func livePins(tx *gorm.DB, roomID uint) *gorm.DB {
+	return tx.Where("pinned_messages.room_id = ?", roomID).
+		Joins("JOIN messages ON messages.id = pinned_messages.message_id").
+		Where("messages.deleted_at IS NULL AND messages.removed_at IS NULL").
+		Where("messages.expires_at IS NULL OR messages.expires_at > NOW()")
+}
+
+func PinMessage(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		message, ok := loadPinnableMessage(c)
+		if !ok {
+			return
+		}
+
+		if message.IsDeleted {
+			c.JSON(http.StatusGone, gin.H{"error": "Сообщение удалено"})
+			return
+		}
+
+		userID := c.GetUint("user_id")
+		pin := models.PinnedMessage{
+			MessageID: message.ID,
+			RoomID:    message.RoomID,
+			PinnedBy:  userID,
+			PinnedAt:  time.Now(),
+		}
+
+		var created bool
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			// Lock the room row so concurrent pins cannot exceed the cap.
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Room{}, message.RoomID).Error; err != nil {
+				return err
+			}
+
+			var existing models.PinnedMessage
+			if err := tx.Where("message_id = ?", message.ID).First(&existing).Error; err == nil {
+				pin = existing
+				return nil
+			}
+
+			var count int64
+			if err := livePins(tx, message.RoomID).Model(&models.PinnedMessage{}).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= maxPinnedMessages {
+				return errPinLimit
+			}
+
+			created = true
+			return tx.Create(&pin).Error
+		})
+		if err == errPinLimit {
+			c.JSON(http.StatusConflict, gin.H{"error": "Достигнуто максимальное количество закрепленных сообщений", "max_pins": maxPinnedMessages})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при закреплении сообщения"})
+			return
+		}
+
+		db.DB.First(&pin.Pinner, pin.PinnedBy)
+
+		if created {
+			broadcastRoomEvent(manager, message.RoomID, "message_pinned", map[string]interface{}{
+				"message_id": message.ID,
+				"parent_id":  message.ParentID,
+				"pinned_by":  pin.PinnedBy,
+				"pinner":     pin.Pinner,
+				"pinned_at":  pin.PinnedAt.Format(time.RFC3339),
+			})
+		}
+
+		c.JSON(http.StatusOK, pin)
+	}
+}
+
+func UnpinMessage(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		message, ok := loadPinnableMessage(c)
+		if !ok {
+			return
+		}
+
+		result := db.DB.Where("message_id = ?", message.ID).Delete(&models.PinnedMessage{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при откреплении сообщения"})
+			return
+		}
+
+		if result.RowsAffected > 0 {
+			broadcastRoomEvent(manager, message.RoomID, "message_unpinned", map[string]interface{}{
+				"message_id":  message.ID,
+				"unpinned_by": c.GetUint("user_id"),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Сообщение откреплено", "message_id": message.ID})
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostingError describes why a user may not post in a room right now.
+type PostingError struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter int
+}
+
+// Event encodes the error as a WebSocket event for the sender.
+func (e *PostingError) Event() []byte {
+	event := map[string]interface{}{
+		"type":      "error",
+		"code":      e.Code,
+		"error":     e.Message,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	if e.RetryAfter > 0 {
+		event["retry_after"] = e.RetryAfter
+	}
+	data, _ := json.Marshal(event)
+	return data
+}
+
+func (e *PostingError) Error() string {
+	return e.Message
+}
+
+func respondPostingError(c *gin.Context, e *PostingError) {
+	body := gin.H{"error": e.Message, "code": e.Code}
+	if e.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+		body["retry_after"] = e.RetryAfter
+	}
+	c.JSON(e.Status, body)
+}
+
+// workspaceRooms scopes a room query to the workspace selected for the request.
+func workspaceRooms(c *gin.Context) *gorm.DB {
+	return db.DB.Where("workspace_id = ?", c.GetUint("workspace_id"))
+}
+
+// memberRoomIDs selects the IDs of the rooms in rooms that userID owns or
+// has joined.
+func memberRoomIDs(rooms *gorm.DB, userID uint) *gorm.DB {
+	return rooms.Model(&models.Room{}).Select("id").
+		Where("owner_id = ? OR id IN (?)", userID,
+			db.DB.Model(&models.RoomMember{}).Select("room_id").Where("user_id = ?", userID))
+}
+
+// getRoomRole returns the role userID holds in room, or an empty string
+// if the user is not a member. Workspace admins act as room admins.
+func getRoomRole(room *models.Room, userID uint) string {
+	if room.OwnerID == userID {
+		return models.RoomRoleOwner
+	}
+
+	role := ""
+	var member models.RoomMember
+	if err := db.DB.Where("room_id = ? AND user_id = ?", room.ID, userID).First(&member).Error; err == nil {
+		role = member.Role
+	}
+
+	if !member.IsAdmin() && isWorkspaceAdmin(room.WorkspaceID, userID) {
+		return models.RoomRoleAdmin
+	}
+	return role
+}
+
+func isWorkspaceAdmin(workspaceID, userID uint) bool {
+	return models.WorkspaceMember{Role: getWorkspaceRole(workspaceID, userID)}.IsAdmin()
+}
+
+// canViewRoom reports whether userID may read the room's content.
+func canViewRoom(room *models.Room, userID uint) bool {
+	return !room.IsPrivate || getRoomRole(room, userID) != ""
+}
+
+func isRoomAdmin(room *models.Room, userID uint) bool {
+	return models.RoomMember{Role: getRoomRole(room, userID)}.IsAdmin()
+}
+
+func isRoomModerator(room *models.Room, userID uint) bool {
+	return models.RoomMember{Role: getRoomRole(room, userID)}.IsModerator()
+}
+
+// slowModeWait returns how long userID has to wait before posting in room
+// again. It is derived from the user's latest stored message, so only
+// messages that were actually posted count.
+func slowModeWait(q *gorm.DB, room *models.Room, userID uint) time.Duration {
+	if room.SlowModeSeconds <= 0 || isRoomModerator(room, userID) {
+		return 0
+	}
+
+	var last models.Message
+	if err := q.Unscoped().Where("room_id = ? AND user_id = ?", room.ID, userID).
+		Order("created_at DESC").
+		First(&last).Error; err != nil {
+		return 0
+	}
+
+	wait := time.Duration(room.SlowModeSeconds)*time.Second - time.Since(last.CreatedAt)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// lockSlowMode re-checks slow mode inside the transaction that stores a
+// new message. Locking the user's row serializes their sends, so two
+// concurrent messages cannot both pass the check, and a send that fails
+// never counts.
+func lockSlowMode(tx *gorm.DB, room *models.Room, userID uint) error {
+	if room.SlowModeSeconds <= 0 {
+		return nil
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error; err != nil {
+		return err
+	}
+	if wait := slowModeWait(tx, room, userID); wait > 0 {
+		return slowModeError(wait)
+	}
+	return nil
+}
+
+func slowModeError(wait time.Duration) *PostingError {
+	return &PostingError{
+		Status:     http.StatusTooManyRequests,
+		Code:       "slow_mode",
+		Message:    "В комнате включен медленный режим",
+		RetryAfter: int(math.Ceil(wait.Seconds())),
+	}
+}
+
+// mutedFor returns how long userID stays muted in room.
+func mutedFor(room *models.Room, userID uint) time.Duration {
+	var member models.RoomMember
+	if err := db.DB.Where("room_id = ? AND user_id = ? AND muted_until > ?", room.ID, userID, time.Now()).
+		First(&member).Error; err != nil {
+		return 0
+	}
+	return time.Until(*member.MutedUntil)
+}
+
+// checkCanPost applies the room posting rules to a new message.
+func checkCanPost(room *models.Room, userID uint) *PostingError {
+	return checkPostingRules(room, userID, true)
+}
+
+// checkPostingRules checks the posting rules; slowMode says whether slow
+// mode applies, which it does not for uploads and typing indicators.
+func checkPostingRules(room *models.Room, userID uint, slowMode bool) *PostingError {
+	if muted := mutedFor(room, userID); muted > 0 {
+		return &PostingError{
+			Status:     http.StatusForbidden,
+			Code:       "muted",
+			Message:    "Вы не можете писать в этой комнате",
+			RetryAfter: int(math.Ceil(muted.Seconds())),
+		}
+	}
+	if room.PostingPolicy == models.PostingPolicyAdmins && !isRoomAdmin(room, userID) {
+		return &PostingError{
+			Status:  http.StatusForbidden,
+			Code:    "read_only",
+			Message: "В этой комнате могут писать только администраторы",
+		}
+	}
+	if !slowMode {
+		return nil
+	}
+	if wait := slowModeWait(db.DB, room, userID); wait > 0 {
+		return slowModeError(wait)
+	}
+	return nil
+}
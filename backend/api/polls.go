@@ -0,0 +1,394 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	minPollOptions    = 2
+	maxPollOptions    = 10
+	maxPollOptionSize = 200
+)
+
+// CreatePollRequest turns a new message into a poll; the message content
+// is the question.
+type CreatePollRequest struct {
+	Options        []string   `json:"options" binding:"required"`
+	MultipleChoice bool       `json:"multiple_choice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closes_at"`
+}
+
+type PollVoteRequest struct {
+	OptionIDs []uint `json:"option_ids" binding:"required,min=1"`
+}
+
+var (
+	errPollClosed         = errors.New("poll closed")
+	errInvalidPollOptions = errors.New("invalid poll options")
+)
+
+// newPoll validates a poll request and builds the poll to be stored with
+// its message.
+func newPoll(req *CreatePollRequest, now time.Time) (*models.Poll, error) {
+	if len(req.Options) < minPollOptions || len(req.Options) > maxPollOptions {
+		return nil, errors.New("Опрос должен содержать от 2 до 10 вариантов")
+	}
+	if req.ClosesAt != nil && !req.ClosesAt.After(now) {
+		return nil, errors.New("Время завершения опроса должно быть в будущем")
+	}
+
+	poll := &models.Poll{
+		MultipleChoice: req.MultipleChoice,
+		Anonymous:      req.Anonymous,
+		ClosesAt:       req.ClosesAt,
+		CreatedAt:      now,
+	}
+	seen := make(map[string]bool, len(req.Options))
+	for i, text := range req.Options {
+		text = strings.TrimSpace(text)
+		if text == "" || utf8.RuneCountInString(text) > maxPollOptionSize {
+			return nil, errors.New("Неверный вариант ответа")
+		}
+		key := strings.ToLower(text)
+		if seen[key] {
+			return nil, errors.New("Варианты ответа не должны повторяться")
+		}
+		seen[key] = true
+		poll.Options = append(poll.Options, models.PollOption{Position: i, Text: text})
+	}
+	return poll, nil
+}
+
+// attachPollResults fills in vote counts for the polls among the given
+// messages from the point of view of userID. Voters are listed only for
+// polls with visible voting.
+func attachPollResults(messages []models.Message, userID uint) error {
+	polls := make([]*models.Poll, 0)
+	for i := range messages {
+		if messages[i].Poll != nil {
+			polls = append(polls, messages[i].Poll)
+		}
+	}
+	return fillPollResults(polls, userID)
+}
+
+func fillPollResults(polls []*models.Poll, userID uint) error {
+	if len(polls) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(polls))
+	options := make(map[uint]*models.PollOption)
+	byID := make(map[uint]*models.Poll, len(polls))
+	for i, poll := range polls {
+		ids[i] = poll.ID
+		byID[poll.ID] = poll
+		poll.TotalVoters = 0
+		for j := range poll.Options {
+			option := &poll.Options[j]
+			option.VoteCount = 0
+			option.VotedByMe = false
+			option.Voters = nil
+			if !poll.Anonymous {
+				option.Voters = []uint{}
+			}
+			options[option.ID] = option
+		}
+	}
+
+	var votes []models.PollVote
+	if err := db.DB.Where("poll_id IN ?", ids).Order("created_at").Find(&votes).Error; err != nil {
+		return err
+	}
+
+	voters := make(map[uint]map[uint]bool, len(polls))
+	for _, vote := range votes {
+		option, ok := options[vote.OptionID]
+		if !ok {
+			continue
+		}
+		option.VoteCount++
+		if vote.UserID == userID {
+			option.VotedByMe = true
+		}
+		if option.Voters != nil {
+			option.Voters = append(option.Voters, vote.UserID)
+		}
+		if voters[vote.PollID] == nil {
+			voters[vote.PollID] = make(map[uint]bool)
+		}
+		voters[vote.PollID][vote.UserID] = true
+	}
+	for pollID, users := range voters {
+		byID[pollID].TotalVoters = len(users)
+	}
+	return nil
+}
+
+// deleteMessagePolls removes the polls of the given messages with their
+// options and votes.
+func deleteMessagePolls(tx *gorm.DB, messageIDs []uint) error {
+	pollIDs := tx.Model(&models.Poll{}).Select("id").Where("message_id IN ?", messageIDs)
+	if err := tx.Where("poll_id IN (?)", pollIDs).Delete(&models.PollVote{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("poll_id IN (?)", pollIDs).Delete(&models.PollOption{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("message_id IN ?", messageIDs).Delete(&models.Poll{}).Error
+}
+
+// loadPoll loads the poll of the message from the :id parameter and
+// checks that the caller can see its room. It writes the error response
+// itself.
+func loadPoll(c *gin.Context) (models.Message, models.Room, bool) {
+	var message models.Message
+	var room models.Room
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return message, room, false
+	}
+
+	if err := db.DB.Preload("Poll.Options", func(q *gorm.DB) *gorm.DB {
+		return q.Order("position")
+	}).First(&message, messageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return message, room, false
+	}
+
+	if err := workspaceRooms(c).First(&room, message.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return message, room, false
+	}
+
+	if !canViewRoom(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return message, room, false
+	}
+
+	if message.IsDeleted {
+		c.JSON(http.StatusGone, gin.H{"error": "Сообщение удалено"})
+		return message, room, false
+	}
+
+	if message.Poll == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Опрос не найден"})
+		return message, room, false
+	}
+
+	return message, room, true
+}
+
+// respondPollResults broadcasts the poll's new results to the room and
+// returns them to the caller with their own votes marked.
+func respondPollResults(c *gin.Context, manager *services.WebSocketManager, message *models.Message) {
+	poll := message.Poll
+	if err := fillPollResults([]*models.Poll{poll}, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении результатов опроса"})
+		return
+	}
+	broadcastRoomEvent(manager, message.RoomID, "poll_updated", map[string]interface{}{
+		"message_id": message.ID,
+		"poll":       poll,
+	})
+
+	if err := fillPollResults([]*models.Poll{poll}, c.GetUint("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении результатов опроса"})
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
+
+// VotePoll records the caller's vote. In single-choice polls it replaces
+// any earlier vote; in multiple-choice polls the options are added to it.
+func VotePoll(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PollVoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		message, room, ok := loadPoll(c)
+		if !ok {
+			return
+		}
+
+		userID := c.GetUint("user_id")
+		if getRoomRole(&room, userID) == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Голосовать могут только участники комнаты"})
+			return
+		}
+
+		poll := message.Poll
+		optionIDs := uniqueIDs(req.OptionIDs)
+		if !poll.MultipleChoice && len(optionIDs) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "В этом опросе можно выбрать только один вариант"})
+			return
+		}
+
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			// Lock the poll so closing and re-voting serialize with votes.
+			var locked models.Poll
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, poll.ID).Error; err != nil {
+				return err
+			}
+			if locked.Closed() {
+				return errPollClosed
+			}
+
+			var count int64
+			if err := tx.Model(&models.PollOption{}).
+				Where("poll_id = ? AND id IN ?", poll.ID, optionIDs).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if int(count) != len(optionIDs) {
+				return errInvalidPollOptions
+			}
+
+			if !poll.MultipleChoice {
+				if err := tx.Where("poll_id = ? AND user_id = ?", poll.ID, userID).Delete(&models.PollVote{}).Error; err != nil {
+					return err
+				}
+			}
+
+			now := time.Now()
+			votes := make([]models.PollVote, 0, len(optionIDs))
+			for _, optionID := range optionIDs {
+				votes = append(votes, models.PollVote{PollID: poll.ID, OptionID: optionID, UserID: userID, CreatedAt: now})
+			}
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&votes).Error
+		})
+		if err == errPollClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Опрос завершен"})
+			return
+		}
+		if err == errInvalidPollOptions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный вариант ответа"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при голосовании"})
+			return
+		}
+
+		respondPollResults(c, manager, &message)
+	}
+}
+
+// RetractPollVote removes the caller's vote, or only the option given in
+// the option_id query parameter.
+func RetractPollVote(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		message, room, ok := loadPoll(c)
+		if !ok {
+			return
+		}
+
+		userID := c.GetUint("user_id")
+		if getRoomRole(&room, userID) == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Голосовать могут только участники комнаты"})
+			return
+		}
+
+		if message.Poll.Closed() {
+			c.JSON(http.StatusConflict, gin.H{"error": "Опрос завершен"})
+			return
+		}
+
+		var optionID *uint64
+		if raw := c.Query("option_id"); raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный вариант ответа"})
+				return
+			}
+			optionID = &id
+		}
+
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			// Lock the poll so a retraction cannot slip past a concurrent close.
+			var locked models.Poll
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, message.Poll.ID).Error; err != nil {
+				return err
+			}
+			if locked.Closed() {
+				return errPollClosed
+			}
+
+			query := tx.Where("poll_id = ? AND user_id = ?", message.Poll.ID, userID)
+			if optionID != nil {
+				query = query.Where("option_id = ?", *optionID)
+			}
+			return query.Delete(&models.PollVote{}).Error
+		})
+		if err == errPollClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Опрос завершен"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при отмене голоса"})
+			return
+		}
+
+		respondPollResults(c, manager, &message)
+	}
+}
+
+// ClosePoll stops voting early. Only the author and room moderators can
+// close a poll.
+func ClosePoll(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		message, room, ok := loadPoll(c)
+		if !ok {
+			return
+		}
+
+		userID := c.GetUint("user_id")
+		if message.UserID != userID && !isRoomModerator(&room, userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Завершить опрос может только автор или модератор"})
+			return
+		}
+
+		if !message.Poll.Closed() {
+			now := time.Now()
+			if err := db.DB.Model(message.Poll).Update("closed_at", now).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при завершении опроса"})
+				return
+			}
+			message.Poll.ClosedAt = &now
+		}
+		message.Poll.IsClosed = true
+
+		respondPollResults(c, manager, &message)
+	}
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxDistinctReactions caps the number of different emoji on one message.
+const maxDistinctReactions = 20
+
+var errReactionLimit = errors.New("reaction limit reached")
+
+type AddReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 64 || utf8.RuneCountInString(emoji) > 16 {
+		return false
+	}
+
+	// An emoji is one or more elements joined by ZWJ. Each element is a
+	// pictograph, a regional indicator pair or a keycap, optionally followed
+	// by variation selectors, a skin tone or tag characters.
+	expectBase, keycap := true, false
+	var prev rune
+	for _, r := range emoji {
+		switch {
+		case expectBase:
+			if isKeycapBase(r) {
+				keycap = true
+			} else if !unicode.Is(emojiPictographs, r) {
+				return false
+			}
+			expectBase = false
+		case r == zeroWidthJoiner:
+			if keycap {
+				return false
+			}
+			expectBase = true
+		case r == combiningKeycap:
+			if !keycap {
+				return false
+			}
+			keycap = false
+		case r == 0xFE0E, r == 0xFE0F:
+		case r >= 0x1F3FB && r <= 0x1F3FF, r >= 0xE0020 && r <= 0xE007F:
+			if keycap {
+				return false
+			}
+		case isRegionalIndicator(r) && isRegionalIndicator(prev):
+			// A flag is exactly two regional indicators.
+			prev = 0
+			continue
+		default:
+			return false
+		}
+		prev = r
+	}
+	return !expectBase && !keycap
+}
+
+const (
+	zeroWidthJoiner = 0x200D
+	combiningKeycap = 0x20E3
+)
+
+func isKeycapBase(r rune) bool {
+	return r == '#' || r == '*' || (r >= '0' && r <= '9')
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// emojiPictographs holds the code points that start an emoji: the
+// Extended_Pictographic ranges outside ASCII, including regional indicators
+// and skin tone modifiers.
+var emojiPictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00A9, Hi: 0x00A9, Stride: 1},
+		{Lo: 0x00AE, Hi: 0x00AE, Stride: 1},
+		{Lo: 0x203C, Hi: 0x203C, Stride: 1},
+		{Lo: 0x2049, Hi: 0x2049, Stride: 1},
+		{Lo: 0x2122, Hi: 0x2122, Stride: 1},
+		{Lo: 0x2139, Hi: 0x2139, Stride: 1},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21A9, Hi: 0x21AA, Stride: 1},
+		{Lo: 0x231A, Hi: 0x231B, Stride: 1},
+		{Lo: 0x2328, Hi: 0x2328, Stride: 1},
+		{Lo: 0x23CF, Hi: 0x23CF, Stride: 1},
+		{Lo: 0x23E9, Hi: 0x23F3, Stride: 1},
+		{Lo: 0x23F8, Hi: 0x23FA, Stride: 1},
+		{Lo: 0x24C2, Hi: 0x24C2, Stride: 1},
+		{Lo: 0x25AA, Hi: 0x25AB, Stride: 1},
+		{Lo: 0x25B6, Hi: 0x25B6, Stride: 1},
+		{Lo: 0x25C0, Hi: 0x25C0, Stride: 1},
+		{Lo: 0x25FB, Hi: 0x25FE, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27BF, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2B05, Hi: 0x2B07, Stride: 1},
+		{Lo: 0x2B1B, Hi: 0x2B1C, Stride: 1},
+		{Lo: 0x2B50, Hi: 0x2B50, Stride: 1},
+		{Lo: 0x2B55, Hi: 0x2B55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303D, Hi: 0x303D, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3297, Stride: 1},
+		{Lo: 0x3299, Hi: 0x3299, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1F000, Hi: 0x1FAFF, Stride: 1},
+	},
+}
+
+// attachReactions fills in aggregated reactions for the given messages from
+// the point of view of userID.
+func attachReactions(messages []models.Message, userID uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(messages))
+	byID := make(map[uint]int, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+		byID[m.ID] = i
+		messages[i].Reactions = []models.ReactionSummary{}
+	}
+
+	var rows []struct {
+		MessageID   uint
+		Emoji       string
+		Count       int
+		ReactedByMe bool
+	}
+	err := db.DB.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted_by_me, MIN(created_at) AS first_at", userID).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").
+		Order("first_at").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		i := byID[r.MessageID]
+		messages[i].Reactions = append(messages[i].Reactions, models.ReactionSummary{
+			Emoji:       r.Emoji,
+			Count:       r.Count,
+			ReactedByMe: r.ReactedByMe,
+		})
+	}
+	return nil
+}
+
+// loadReactableMessage loads the message from the :id parameter and checks
+// that the caller can see it. It writes the error response itself.
+func loadReactableMessage(c *gin.Context) (models.Message, bool) {
+	var message models.Message
+
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return message, false
+	}
+
+	if err := db.DB.First(&message, messageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return message, false
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, message.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return message, false
+	}
+
+	if !canViewRoom(&room, c.GetUint("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return message, false
+	}
+
+	if message.IsDeleted {
+		c.JSON(http.StatusGone, gin.H{"error": "Сообщение удалено"})
+		return message, false
+	}
+
+	return message, true
+}
+
+func reactionCount(messageID uint, emoji string) int64 {
+	var count int64
+	db.DB.Model(&models.MessageReaction{}).Where("message_id = ? AND emoji = ?", messageID, emoji).Count(&count)
+	return count
+}
+
+func AddReaction(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AddReactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !validEmoji(req.Emoji) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверная реакция"})
+			return
+		}
+
+		message, ok := loadReactableMessage(c)
+		if !ok {
+			return
+		}
+
+		userID := c.GetUint("user_id")
+
+		var added bool
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			// Lock the message so concurrent new emoji cannot exceed the cap.
+			var locked models.Message
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, message.ID).Error; err != nil {
+				return err
+			}
+
+			var existing int64
+			if err := tx.Model(&models.MessageReaction{}).
+				Where("message_id = ? AND emoji = ?", message.ID, req.Emoji).
+				Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing == 0 {
+				var distinct int64
+				if err := tx.Model(&models.MessageReaction{}).
+					Where("message_id = ?", message.ID).
+					Distinct("emoji").
+					Count(&distinct).Error; err != nil {
+					return err
+				}
+				if distinct >= maxDistinctReactions {
+					return errReactionLimit
+				}
+			}
+
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MessageReaction{
+				MessageID: message.ID,
+				UserID:    userID,
+				Emoji:     req.Emoji,
+				CreatedAt: time.Now(),
+			})
+			added = result.RowsAffected > 0
+			return result.Error
+		})
+		if err == errReactionLimit {
+			c.JSON(http.StatusConflict, gin.H{"error": "Достигнуто максимальное количество разных реакций"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при добавлении реакции"})
+			return
+		}
+
+		count := reactionCount(message.ID, req.Emoji)
+		if added {
+			broadcastRoomEvent(manager, message.RoomID, "reaction_added", map[string]interface{}{
+				"message_id": message.ID,
+				"user_id":    userID,
+				"emoji":      req.Emoji,
+				"count":      count,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message_id": message.ID, "emoji": req.Emoji, "count": count, "reacted_by_me": true})
+	}
+}
+
+func RemoveReaction(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		emoji := c.Param("emoji")
+		if !validEmoji(emoji) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверная реакция"})
+			return
+		}
+
+		message, ok := loadReactableMessage(c)
+		if !ok {
+			return
+		}
+
+		userID := c.GetUint("user_id")
+
+		result := db.DB.Where("message_id = ? AND user_id = ? AND emoji = ?", message.ID, userID, emoji).
+			Delete(&models.MessageReaction{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении реакции"})
+			return
+		}
+
+		count := reactionCount(message.ID, emoji)
+		if result.RowsAffected > 0 {
+			broadcastRoomEvent(manager, message.RoomID, "reaction_removed", map[string]interface{}{
+				"message_id": message.ID,
+				"user_id":    userID,
+				"emoji":      emoji,
+				"count":      count,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message_id": message.ID, "emoji": emoji, "count": count, "reacted_by_me": false})
+	}
+}
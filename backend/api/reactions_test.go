@@ -0,0 +1,39 @@
+package api
+
+import "testing"
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		valid bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👍🏽", true},
+		{"👩‍💻", true},
+		{"👨‍👩‍👧‍👦", true},
+		{"🏳️‍🌈", true},
+		{"🇰🇿", true},
+		{"1️⃣", true},
+		{"#⃣", true},
+		{"🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"", false},
+		{"a", false},
+		{"ok", false},
+		{"1", false},
+		{"<b>", false},
+		{"👍 ", false},
+		{"👍a", false},
+		{"‍👍", false},
+		{"👍‍", false},
+		{"🇰🇿🇰", false},
+		{"⃣", false},
+		{"️", false},
+	}
+
+	for _, tt := range tests {
+		if got := validEmoji(tt.emoji); got != tt.valid {
+			t.Errorf("validEmoji(%q) = %v, want %v", tt.emoji, got, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MarkReadRequest struct {
+	MessageID uint `json:"message_id"`
+}
+
+// attachUnreadCounts fills in the caller's read position and the number of
+// unread messages and mentions for each room. Only top-level messages count
+// as unread; mentions in thread replies are counted too.
+func attachUnreadCounts(rooms []models.Room, userID uint) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(rooms))
+	byID := make(map[uint]int, len(rooms))
+	for i, r := range rooms {
+		ids[i] = r.ID
+		byID[r.ID] = i
+	}
+
+	var counts []struct {
+		RoomID            uint
+		LastReadMessageID uint
+		UnreadCount       int
+		MentionCount      int
+	}
+	query := `
+		SELECT r.id AS room_id,
+			COALESCE(rm.last_read_message_id, 0) AS last_read_message_id,
+			COUNT(m.id) FILTER (WHERE m.parent_id IS NULL) AS unread_count,
+			COUNT(mm.user_id) AS mention_count
+		FROM rooms r
+		LEFT JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = ?
+		LEFT JOIN messages m ON m.room_id = r.id
+			AND m.id > COALESCE(rm.last_read_message_id, 0)
+			AND m.user_id <> ?
+			AND m.deleted_at IS NULL
+			AND m.removed_at IS NULL
+			AND (m.expires_at IS NULL OR m.expires_at > NOW())
+		LEFT JOIN message_mentions mm ON mm.message_id = m.id AND mm.user_id = ?
+		WHERE r.id IN ?
+		GROUP BY r.id, rm.last_read_message_id
+	`
+	if err := db.DB.Raw(query, userID, userID, userID, ids).Scan(&counts).Error; err != nil {
+		return err
+	}
+
+	for _, count := range counts {
+		i := byID[count.RoomID]
+		rooms[i].LastReadMessageID = count.LastReadMessageID
+		rooms[i].UnreadCount = count.UnreadCount
+		rooms[i].MentionCount = count.MentionCount
+	}
+	return nil
+}
+
+// MarkRoomRead moves the caller's read position in a room forward to the
+// given message, or to the latest message if none is given. The new position
+// is pushed to the caller's other connections so they can clear their badges.
+func MarkRoomRead(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+			return
+		}
+
+		var req MarkReadRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		userID := c.GetUint("user_id")
+
+		var room models.Room
+		if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+			return
+		}
+
+		var membership int64
+		db.DB.Model(&models.RoomMember{}).Where("room_id = ? AND user_id = ?", room.ID, userID).Count(&membership)
+		if membership == 0 && room.OwnerID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Вы не являетесь участником этой комнаты"})
+			return
+		}
+
+		messageID := req.MessageID
+		if messageID == 0 {
+			if err := db.DB.Model(&models.Message{}).
+				Where("room_id = ?", room.ID).
+				Select("COALESCE(MAX(id), 0)").
+				Scan(&messageID).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении позиции чтения"})
+				return
+			}
+		} else {
+			var message models.Message
+			if err := db.DB.First(&message, messageID).Error; err != nil || message.RoomID != room.ID {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+				return
+			}
+		}
+
+		now := time.Now()
+		member := models.RoomMember{
+			RoomID:            room.ID,
+			UserID:            userID,
+			Role:              models.RoomRoleMember,
+			JoinedAt:          now,
+			InvitedBy:         userID,
+			LastReadMessageID: messageID,
+			LastReadAt:        &now,
+		}
+		if userID == room.OwnerID {
+			member.Role = models.RoomRoleOwner
+		}
+
+		// Owners of older rooms may have no membership row yet; the read
+		// position never moves backwards.
+		err = db.DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "room_id"}, {Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"last_read_message_id": gorm.Expr("GREATEST(room_members.last_read_message_id, EXCLUDED.last_read_message_id)"),
+				"last_read_at":         now,
+			}),
+		}).Create(&member).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении позиции чтения"})
+			return
+		}
+
+		if err := db.DB.Where("room_id = ? AND user_id = ?", room.ID, userID).First(&member).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении позиции чтения"})
+			return
+		}
+
+		if err := db.DB.Model(&models.Notification{}).
+			Where("user_id = ? AND room_id = ? AND message_id <= ? AND read_at IS NULL", userID, room.ID, member.LastReadMessageID).
+			Update("read_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении уведомлений"})
+			return
+		}
+
+		event, _ := json.Marshal(map[string]interface{}{
+			"type":                 "read_position",
+			"room_id":              room.ID,
+			"user_id":              userID,
+			"last_read_message_id": member.LastReadMessageID,
+			"timestamp":            now.Format(time.RFC3339),
+		})
+		manager.SendToUser(userID, event)
+
+		c.JSON(http.StatusOK, gin.H{
+			"room_id":              room.ID,
+			"last_read_message_id": member.LastReadMessageID,
+			"last_read_at":         member.LastReadAt,
+		})
+	}
+}
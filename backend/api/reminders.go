@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const reminderBatch = 100
+
+// RunReminders periodically turns due bookmark reminders and /remind
+// reminders into notifications.
+func RunReminders(manager *services.WebSocketManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, send := range []func(*services.WebSocketManager) (int, error){
+			sendDueBookmarkReminders,
+			sendDueReminders,
+		} {
+			for {
+				sent, err := send(manager)
+				if err != nil {
+					log.Printf("Error sending reminders: %v", err)
+					break
+				}
+				if sent < reminderBatch {
+					break
+				}
+			}
+		}
+	}
+}
+
+// sendDueReminders handles one batch of due /remind reminders and returns
+// how many it claimed.
+func sendDueReminders(manager *services.WebSocketManager) (int, error) {
+	var (
+		due           []models.Reminder
+		notifications []models.Notification
+	)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("remind_at <= ? AND sent_at IS NULL", time.Now()).
+			Order("remind_at, id").
+			Limit(reminderBatch).
+			Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		ids := make([]uint, len(due))
+		for i, r := range due {
+			ids[i] = r.ID
+			notifications = append(notifications, models.Notification{
+				UserID:    r.UserID,
+				Type:      models.NotificationTypeReminder,
+				RoomID:    r.RoomID,
+				ActorID:   r.UserID,
+				Preview:   notificationPreview(r.Text),
+				CreatedAt: now,
+			})
+		}
+		if err := tx.Create(&notifications).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Reminder{}).Where("id IN ?", ids).Update("sent_at", now).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sendReminderNotifications(manager, notifications)
+	return len(due), nil
+}
+
+// sendReminderNotifications pushes stored reminder notifications to their
+// users' open connections.
+func sendReminderNotifications(manager *services.WebSocketManager, notifications []models.Notification) {
+	for _, n := range notifications {
+		db.DB.First(&n.Actor, n.ActorID)
+		var room models.Room
+		db.DB.First(&room, n.RoomID)
+
+		event, err := json.Marshal(map[string]interface{}{
+			"type":         "notification",
+			"notification": n,
+			"room_id":      room.ID,
+			"room_name":    room.Name,
+			"timestamp":    n.CreatedAt.Format(time.RFC3339),
+		})
+		if err != nil {
+			continue
+		}
+		manager.SendToUser(n.UserID, event)
+	}
+}
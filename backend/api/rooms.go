@@ -1,37 +1,89 @@
 package api
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Kenzhe14/chat/db"
 	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type CreateRoomRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
-	IsPrivate   bool   `json:"is_private"`
+	Name                   string  `json:"name" binding:"required"`
+	Description            string  `json:"description"`
+	IsPrivate              bool    `json:"is_private"`
+	SlowModeSeconds        *int    `json:"slow_mode_seconds" binding:"omitempty,min=0,max=21600"`
+	PostingPolicy          *string `json:"posting_policy" binding:"omitempty,oneof=everyone admins"`
+	EditWindowSeconds      *int    `json:"edit_window_seconds" binding:"omitempty,min=0"`
+	MaxAttachmentBytes     *int64  `json:"max_attachment_bytes" binding:"omitempty,min=1"`
+	AllowedAttachmentTypes *string `json:"allowed_attachment_types"`
+	MessageTTLSeconds      *int    `json:"message_ttl_seconds" binding:"omitempty,min=0,max=31536000"`
 }
 
 type AddMemberRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin moderator member"`
+}
+
 type RoomMemberResponse struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Status   string `json:"status"`
-	JoinedAt string `json:"joined_at"`
+	ID                uint   `json:"id"`
+	Username          string `json:"username"`
+	Email             string `json:"email"`
+	Status            string `json:"status"`
+	Role              string `json:"role"`
+	JoinedAt          string `json:"joined_at"`
+	InvitedBy         uint   `json:"invited_by"`
+	InvitedByUsername string `json:"invited_by_username,omitempty"`
+	LastReadMessageID uint   `json:"last_read_message_id"`
+	Online            bool   `json:"online"`
+	InRoom            bool   `json:"in_room"`
+}
+
+const (
+	defaultMemberLimit = 50
+	maxMemberLimit     = 200
+)
+
+// memberCursor marks the last member of a page for keyset pagination.
+type memberCursor struct {
+	Rank   int       `json:"r"`
+	Name   string    `json:"n"`
+	Joined time.Time `json:"j"`
+	ID     uint      `json:"i"`
+}
+
+func encodeMemberCursor(cursor memberCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMemberCursor(raw string) (*memberCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor memberCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
 }
 
 func GetRooms(c *gin.Context) {
 	var rooms []models.Room
-	result := db.DB.Find(&rooms)
+	result := workspaceRooms(c).Find(&rooms)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении комнат"})
 		return
@@ -48,10 +100,11 @@ func GetRoom(c *gin.Context) {
 	}
 
 	var room models.Room
-	if err := db.DB.Preload("Owner").First(&room, roomID).Error; err != nil {
+	if err := workspaceRooms(c).Preload("Owner").First(&room, roomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
+	room.CanPost = room.PostingPolicy != models.PostingPolicyAdmins || isRoomAdmin(&room, c.GetUint("user_id"))
 
 	c.JSON(http.StatusOK, room)
 }
@@ -70,6 +123,7 @@ func CreateRoom(c *gin.Context) {
 	}
 
 	room := models.Room{
+		WorkspaceID: c.GetUint("workspace_id"),
 		Name:        req.Name,
 		Description: req.Description,
 		IsPrivate:   req.IsPrivate,
@@ -77,21 +131,52 @@ func CreateRoom(c *gin.Context) {
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
-
-	if err := db.DB.Create(&room).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании комнаты: " + err.Error()})
-		return
+	if req.SlowModeSeconds != nil {
+		room.SlowModeSeconds = *req.SlowModeSeconds
 	}
-
-	roomMember := models.RoomMember{
-		RoomID:    room.ID,
-		UserID:    userID,
-		JoinedAt:  time.Now(),
-		InvitedBy: userID,
+	if req.PostingPolicy != nil {
+		room.PostingPolicy = *req.PostingPolicy
 	}
+	if req.EditWindowSeconds != nil {
+		room.EditWindowSeconds = *req.EditWindowSeconds
+	}
+	if req.MaxAttachmentBytes != nil {
+		room.MaxAttachmentBytes = *req.MaxAttachmentBytes
+	}
+	if req.AllowedAttachmentTypes != nil {
+		room.AllowedAttachmentTypes = *req.AllowedAttachmentTypes
+	}
+	if req.MessageTTLSeconds != nil {
+		room.MessageTTLSeconds = *req.MessageTTLSeconds
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&room).Error; err != nil {
+			return fmt.Errorf("Ошибка при создании комнаты: %w", err)
+		}
+		// A zero edit window is skipped by Create in favour of the column default.
+		if req.EditWindowSeconds != nil && *req.EditWindowSeconds == 0 {
+			if err := tx.Model(&room).Update("edit_window_seconds", 0).Error; err != nil {
+				return fmt.Errorf("Ошибка при создании комнаты: %w", err)
+			}
+		}
+
+		roomMember := models.RoomMember{
+			RoomID:    room.ID,
+			UserID:    userID,
+			Role:      models.RoomRoleOwner,
+			JoinedAt:  time.Now(),
+			InvitedBy: userID,
+		}
 
-	if err := db.DB.Create(&roomMember).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при добавлении пользователя в комнату: " + err.Error()})
+		if err := tx.Create(&roomMember).Error; err != nil {
+			return fmt.Errorf("Ошибка при добавлении пользователя в комнату: %w", err)
+		}
+
+		return recordRoomAudit(tx, room.ID, userID, models.AuditRoomCreate, nil, nil, roomSettings(room))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -125,7 +210,7 @@ func UpdateRoom(c *gin.Context) {
 	}
 
 	var room models.Room
-	if err := db.DB.First(&room, roomID).Error; err != nil {
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
@@ -135,12 +220,38 @@ func UpdateRoom(c *gin.Context) {
 		return
 	}
 
+	before := roomSettings(room)
+
 	room.Name = req.Name
 	room.Description = req.Description
 	room.IsPrivate = req.IsPrivate
+	if req.SlowModeSeconds != nil {
+		room.SlowModeSeconds = *req.SlowModeSeconds
+	}
+	if req.PostingPolicy != nil {
+		room.PostingPolicy = *req.PostingPolicy
+	}
+	if req.EditWindowSeconds != nil {
+		room.EditWindowSeconds = *req.EditWindowSeconds
+	}
+	if req.MaxAttachmentBytes != nil {
+		room.MaxAttachmentBytes = *req.MaxAttachmentBytes
+	}
+	if req.AllowedAttachmentTypes != nil {
+		room.AllowedAttachmentTypes = *req.AllowedAttachmentTypes
+	}
+	if req.MessageTTLSeconds != nil {
+		room.MessageTTLSeconds = *req.MessageTTLSeconds
+	}
 	room.UpdatedAt = time.Now()
 
-	if err := db.DB.Save(&room).Error; err != nil {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&room).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, room.ID, userID, models.AuditRoomUpdate, nil, before, roomSettings(room))
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении комнаты"})
 		return
 	}
@@ -162,7 +273,7 @@ func DeleteRoom(c *gin.Context) {
 	}
 
 	var room models.Room
-	if err := db.DB.First(&room, roomID).Error; err != nil {
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
@@ -172,7 +283,13 @@ func DeleteRoom(c *gin.Context) {
 		return
 	}
 
-	if err := db.DB.Delete(&room).Error; err != nil {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&room).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, room.ID, userID, models.AuditRoomDelete, nil, roomSettings(room), nil)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении комнаты"})
 		return
 	}
@@ -180,60 +297,150 @@ func DeleteRoom(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Комната успешно удалена"})
 }
 
-func GetRoomMembers(c *gin.Context) {
-	roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
-		return
-	}
+// GetRoomMembers returns a page of room members with their role, inviter and
+// live presence. Members can be searched by username or email prefix ("q") and
+// sorted by "name", "role" or "joined"; pass "next_cursor" from the previous
+// page as "cursor" to continue.
+func GetRoomMembers(manager *services.WebSocketManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+			return
+		}
 
-	var room models.Room
-	if err := db.DB.First(&room, roomID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
-		return
-	}
+		var room models.Room
+		if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+			return
+		}
 
-	userID := c.GetUint("user_id")
-	if room.IsPrivate {
-		var member models.RoomMember
-		if err := db.DB.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+		if !canViewRoom(&room, c.GetUint("user_id")) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
 			return
 		}
-	}
 
-	var members []struct {
-		UserID    uint
-		JoinedAt  time.Time
-		InvitedBy uint
-		Username  string
-		Email     string
-		Status    string
-	}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultMemberLimit)))
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+			return
+		}
+		if limit > maxMemberLimit {
+			limit = maxMemberLimit
+		}
 
-	query := `
-		SELECT rm.user_id, rm.joined_at, rm.invited_by, u.username, u.email, u.status 
-		FROM room_members rm 
-		JOIN users u ON rm.user_id = u.id 
-		WHERE rm.room_id = ?
-	`
-	if err := db.DB.Raw(query, roomID).Scan(&members).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении участников комнаты"})
-		return
-	}
+		sort := c.DefaultQuery("sort", "name")
+		if sort != "name" && sort != "role" && sort != "joined" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверная сортировка"})
+			return
+		}
+
+		var cursor *memberCursor
+		if raw := c.Query("cursor"); raw != "" {
+			if cursor, err = decodeMemberCursor(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+				return
+			}
+		}
+
+		query := db.DB.Table("room_members rm").
+			Joins("JOIN users u ON u.id = rm.user_id AND u.deleted_at IS NULL").
+			Where("rm.room_id = ?", room.ID)
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			pattern := likePrefix(q)
+			query = query.Where(`LOWER(u.username) LIKE ? ESCAPE '\' OR LOWER(u.email) LIKE ? ESCAPE '\'`, pattern, pattern)
+		}
+
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении участников комнаты"})
+			return
+		}
 
-	var response []RoomMemberResponse
-	for _, m := range members {
-		response = append(response, RoomMemberResponse{
-			ID:       m.UserID,
-			Username: m.Username,
-			Email:    m.Email,
-			Status:   m.Status,
-			JoinedAt: m.JoinedAt.Format(time.RFC3339),
+		rank := fmt.Sprintf(`CASE WHEN rm.user_id = %d OR rm.role = '%s' THEN 0 WHEN rm.role = '%s' THEN 1 WHEN rm.role = '%s' THEN 2 ELSE 3 END`,
+			room.OwnerID, models.RoomRoleOwner, models.RoomRoleAdmin, models.RoomRoleModerator)
+
+		switch sort {
+		case "name":
+			if cursor != nil {
+				query = query.Where("(LOWER(u.username), rm.user_id) > (?, ?)", cursor.Name, cursor.ID)
+			}
+			query = query.Order("LOWER(u.username)").Order("rm.user_id")
+		case "joined":
+			if cursor != nil {
+				query = query.Where("(rm.joined_at, rm.user_id) > (?, ?)", cursor.Joined, cursor.ID)
+			}
+			query = query.Order("rm.joined_at").Order("rm.user_id")
+		case "role":
+			if cursor != nil {
+				query = query.Where("("+rank+", LOWER(u.username), rm.user_id) > (?, ?, ?)", cursor.Rank, cursor.Name, cursor.ID)
+			}
+			query = query.Order(rank).Order("LOWER(u.username)").Order("rm.user_id")
+		}
+
+		var members []struct {
+			UserID            uint
+			Username          string
+			Email             string
+			Status            string
+			Role              string
+			RoleRank          int
+			JoinedAt          time.Time
+			InvitedBy         uint
+			InvitedByUsername string
+			LastReadMessageID uint
+		}
+		err = query.Select("rm.user_id, u.username, u.email, u.status, rm.role, " + rank + " AS role_rank, " +
+			"rm.joined_at, rm.invited_by, COALESCE(inviter.username, '') AS invited_by_username, rm.last_read_message_id").
+			Joins("LEFT JOIN users inviter ON inviter.id = rm.invited_by").
+			Limit(limit + 1).
+			Scan(&members).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении участников комнаты"})
+			return
+		}
+
+		var nextCursor string
+		if len(members) > limit {
+			members = members[:limit]
+			last := members[limit-1]
+			nextCursor = encodeMemberCursor(memberCursor{
+				Rank:   last.RoleRank,
+				Name:   strings.ToLower(last.Username),
+				Joined: last.JoinedAt,
+				ID:     last.UserID,
+			})
+		}
+
+		online, inRoom := manager.Presence(room.ID)
+
+		response := []RoomMemberResponse{}
+		for _, m := range members {
+			role := m.Role
+			if m.UserID == room.OwnerID {
+				role = models.RoomRoleOwner
+			}
+			response = append(response, RoomMemberResponse{
+				ID:                m.UserID,
+				Username:          m.Username,
+				Email:             m.Email,
+				Status:            m.Status,
+				Role:              role,
+				JoinedAt:          m.JoinedAt.Format(time.RFC3339),
+				InvitedBy:         m.InvitedBy,
+				InvitedByUsername: m.InvitedByUsername,
+				LastReadMessageID: m.LastReadMessageID,
+				Online:            online[m.UserID],
+				InRoom:            inRoom[m.UserID],
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"members":     response,
+			"total":       total,
+			"next_cursor": nextCursor,
 		})
 	}
-
-	c.JSON(http.StatusOK, response)
 }
 
 func AddRoomMember(c *gin.Context) {
@@ -250,7 +457,7 @@ func AddRoomMember(c *gin.Context) {
 	}
 
 	var room models.Room
-	if err := db.DB.First(&room, roomID).Error; err != nil {
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
@@ -274,6 +481,11 @@ func AddRoomMember(c *gin.Context) {
 		return
 	}
 
+	if getWorkspaceRole(room.WorkspaceID, user.ID) == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Пользователь не является участником рабочего пространства"})
+		return
+	}
+
 	var existingMember models.RoomMember
 	result := db.DB.Where("room_id = ? AND user_id = ?", roomID, user.ID).First(&existingMember)
 	if result.Error == nil {
@@ -283,21 +495,30 @@ func AddRoomMember(c *gin.Context) {
 	member := models.RoomMember{
 		RoomID:    uint(roomID),
 		UserID:    user.ID,
+		Role:      models.RoomRoleMember,
 		JoinedAt:  time.Now(),
 		InvitedBy: inviterID,
 	}
 
-	if err := db.DB.Create(&member).Error; err != nil {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, room.ID, inviterID, models.AuditMemberAdd, &user.ID, nil, roomMemberState(member))
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при добавлении пользователя в комнату"})
 		return
 	}
 
 	response := RoomMemberResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Status:   user.Status,
-		JoinedAt: member.JoinedAt.Format(time.RFC3339),
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Status:    user.Status,
+		Role:      member.Role,
+		JoinedAt:  member.JoinedAt.Format(time.RFC3339),
+		InvitedBy: member.InvitedBy,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -317,7 +538,7 @@ func RemoveRoomMember(c *gin.Context) {
 	}
 
 	var room models.Room
-	if err := db.DB.First(&room, roomID).Error; err != nil {
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
 		return
 	}
@@ -334,7 +555,19 @@ func RemoveRoomMember(c *gin.Context) {
 		return
 	}
 
-	if err := db.DB.Where("room_id = ? AND user_id = ?", roomID, userID).Delete(&models.RoomMember{}).Error; err != nil {
+	var member models.RoomMember
+	if err := db.DB.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не является участником комнаты"})
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("room_id = ? AND user_id = ?", roomID, userID).Delete(&models.RoomMember{}).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, room.ID, removerID, models.AuditMemberRemove, &member.UserID, roomMemberState(member), nil)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении участника"})
 		return
 	}
@@ -342,6 +575,65 @@ func RemoveRoomMember(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Участник успешно удален из комнаты"})
 }
 
+func UpdateRoomMemberRole(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return
+	}
+
+	var req UpdateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, roomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return
+	}
+
+	actorID := c.GetUint("user_id")
+	if room.OwnerID != actorID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Вы не являетесь владельцем этой комнаты"})
+		return
+	}
+
+	if uint(userID) == room.OwnerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Нельзя изменить роль владельца комнаты"})
+		return
+	}
+
+	var member models.RoomMember
+	if err := db.DB.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не является участником комнаты"})
+		return
+	}
+
+	before := roomMemberState(member)
+	member.Role = req.Role
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.RoomMember{}).Where("room_id = ? AND user_id = ?", roomID, userID).Update("role", req.Role).Error; err != nil {
+			return err
+		}
+		return recordRoomAudit(tx, room.ID, actorID, models.AuditMemberRoleUpdate, &member.UserID, before, roomMemberState(member))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при изменении роли участника"})
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
 func GetUserRooms(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	if userID == 0 {
@@ -380,10 +672,15 @@ func GetUserRooms(c *gin.Context) {
 	}
 
 	var rooms []models.Room
-	if err := db.DB.Preload("Owner").Where("id IN ?", uniqueRoomIDs).Find(&rooms).Error; err != nil {
+	if err := workspaceRooms(c).Preload("Owner").Where("id IN ?", uniqueRoomIDs).Find(&rooms).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении комнат: " + err.Error()})
 		return
 	}
 
+	if err := attachUnreadCounts(rooms, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при подсчете непрочитанных сообщений: " + err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, rooms)
 }
@@ -0,0 +1,457 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxScheduleAhead    = 365 * 24 * time.Hour
+	maxPendingScheduled = 100
+	// scheduleClockSkew tolerates client clocks running slightly behind.
+	scheduleClockSkew = 30 * time.Second
+)
+
+type ScheduleMessageRequest struct {
+	RoomID   uint   `json:"room_id" binding:"required"`
+	ParentID *uint  `json:"parent_id"`
+	Content  string `json:"content" binding:"required"`
+	Format   string `json:"format" binding:"omitempty,oneof=plain markdown"`
+	SendAt   string `json:"send_at" binding:"required"`
+	Timezone string `json:"timezone"`
+}
+
+type UpdateScheduledMessageRequest struct {
+	Content  *string `json:"content"`
+	Format   *string `json:"format" binding:"omitempty,oneof=plain markdown"`
+	SendAt   *string `json:"send_at"`
+	Timezone *string `json:"timezone"`
+}
+
+var errInvalidSendAt = errors.New("invalid send time")
+
+// parseSendAt reads a send time either as RFC 3339 with an explicit offset
+// or as a local date-time ("2006-01-02T15:04[:05]") in the given timezone.
+func parseSendAt(value, timezone string) (time.Time, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, errInvalidSendAt
+}
+
+func validateSendAt(sendAt time.Time) string {
+	now := time.Now()
+	if sendAt.Before(now.Add(-scheduleClockSkew)) {
+		return "Время отправки уже прошло"
+	}
+	if sendAt.After(now.Add(maxScheduleAhead)) {
+		return "Сообщение можно запланировать не более чем на год вперед"
+	}
+	return ""
+}
+
+// findOwnScheduledMessage loads the caller's scheduled message named by the
+// :id param within the current workspace. It writes the error response
+// itself.
+func findOwnScheduledMessage(c *gin.Context) (*models.ScheduledMessage, bool) {
+	scheduledID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID сообщения"})
+		return nil, false
+	}
+
+	var scheduled models.ScheduledMessage
+	err = db.DB.Where("id = ? AND user_id = ?", scheduledID, c.GetUint("user_id")).
+		Where("room_id IN (?)", workspaceRooms(c).Model(&models.Room{}).Select("id")).
+		First(&scheduled).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Запланированное сообщение не найдено"})
+		return nil, false
+	}
+	return &scheduled, true
+}
+
+// respondScheduledCommand rejects slash commands, which run when sent and
+// cannot be scheduled. "//" still schedules a message starting with "/".
+func respondScheduledCommand(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Команды нельзя отправить по расписанию. Чтобы начать сообщение с /, используйте //"})
+}
+
+// ScheduleMessage stores a message to be posted at send_at. A send_at
+// without an offset is read in the given IANA timezone (UTC by default).
+func ScheduleMessage(c *gin.Context) {
+	var req ScheduleMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if messageTooLong(req.Content) {
+		respondMessageTooLong(c)
+		return
+	}
+	if _, _, ok := parseCommand(req.Content); ok {
+		respondScheduledCommand(c)
+		return
+	}
+
+	userID := c.GetUint("user_id")
+
+	if strings.TrimSpace(req.Content) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение не может быть пустым"})
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+
+	sendAt, err := parseSendAt(req.SendAt, req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверное время отправки или часовой пояс"})
+		return
+	}
+	if msg := validateSendAt(sendAt); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var room models.Room
+	if err := workspaceRooms(c).First(&room, req.RoomID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Комната не найдена"})
+		return
+	}
+	if !canViewRoom(&room, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет доступа к этой комнате"})
+		return
+	}
+	if room.PostingPolicy == models.PostingPolicyAdmins && !isRoomAdmin(&room, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "В этой комнате могут писать только администраторы"})
+		return
+	}
+
+	if req.ParentID != nil {
+		var parent models.Message
+		if err := db.DB.First(&parent, *req.ParentID).Error; err != nil || parent.RoomID != room.ID || parent.ParentID != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Родительское сообщение не найдено"})
+			return
+		}
+	}
+
+	var pending int64
+	if err := db.DB.Model(&models.ScheduledMessage{}).
+		Where("user_id = ? AND status = ?", userID, models.ScheduledStatusPending).
+		Count(&pending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при планировании сообщения"})
+		return
+	}
+	if pending >= maxPendingScheduled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Слишком много запланированных сообщений", "max_pending": maxPendingScheduled})
+		return
+	}
+
+	format := req.Format
+	if format == "" {
+		format = models.MessageFormatPlain
+	}
+	scheduled := models.ScheduledMessage{
+		UserID:   userID,
+		RoomID:   room.ID,
+		ParentID: req.ParentID,
+		Content:  req.Content,
+		Format:   format,
+		SendAt:   sendAt,
+		Timezone: req.Timezone,
+		Status:   models.ScheduledStatusPending,
+	}
+	if err := db.DB.Create(&scheduled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при планировании сообщения"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, scheduled)
+}
+
+// GetScheduledMessages lists the caller's scheduled messages in the current
+// workspace, soonest first. Pending ones are returned unless status is
+// given; room_id narrows the list to one room.
+func GetScheduledMessages(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	query := db.DB.Where("user_id = ?", userID).
+		Where("room_id IN (?)", workspaceRooms(c).Model(&models.Room{}).Select("id")).
+		Where("status = ?", c.DefaultQuery("status", models.ScheduledStatusPending))
+
+	if roomID := c.Query("room_id"); roomID != "" {
+		id, err := strconv.ParseUint(roomID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID комнаты"})
+			return
+		}
+		query = query.Where("room_id = ?", id)
+	}
+
+	var scheduled []models.ScheduledMessage
+	if err := query.Order("send_at, id").Limit(maxPendingScheduled).Find(&scheduled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении запланированных сообщений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, scheduled)
+}
+
+// UpdateScheduledMessage changes the content or send time of a pending
+// scheduled message.
+func UpdateScheduledMessage(c *gin.Context) {
+	var req UpdateScheduledMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Content != nil && messageTooLong(*req.Content) {
+		respondMessageTooLong(c)
+		return
+	}
+	if req.Content != nil {
+		if _, _, ok := parseCommand(*req.Content); ok {
+			respondScheduledCommand(c)
+			return
+		}
+	}
+
+	scheduled, ok := findOwnScheduledMessage(c)
+	if !ok {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Content != nil {
+		if strings.TrimSpace(*req.Content) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение не может быть пустым"})
+			return
+		}
+		updates["content"] = *req.Content
+	}
+	if req.Format != nil {
+		updates["format"] = *req.Format
+	}
+	if req.SendAt != nil || req.Timezone != nil {
+		value := scheduled.SendAt.Format(time.RFC3339)
+		if req.SendAt != nil {
+			value = *req.SendAt
+		}
+		timezone := scheduled.Timezone
+		if req.Timezone != nil {
+			timezone = *req.Timezone
+		}
+		sendAt, err := parseSendAt(value, timezone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверное время отправки или часовой пояс"})
+			return
+		}
+		if msg := validateSendAt(sendAt); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		updates["send_at"] = sendAt
+		updates["timezone"] = timezone
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusOK, scheduled)
+		return
+	}
+
+	// The status check keeps the edit from racing the scheduler.
+	result := db.DB.Model(scheduled).Where("status = ?", models.ScheduledStatusPending).Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при обновлении запланированного сообщения"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Сообщение уже отправлено или отменено"})
+		return
+	}
+
+	db.DB.First(scheduled, scheduled.ID)
+	c.JSON(http.StatusOK, scheduled)
+}
+
+// CancelScheduledMessage cancels a pending scheduled message.
+func CancelScheduledMessage(c *gin.Context) {
+	scheduled, ok := findOwnScheduledMessage(c)
+	if !ok {
+		return
+	}
+
+	result := db.DB.Model(scheduled).Where("status = ?", models.ScheduledStatusPending).
+		Update("status", models.ScheduledStatusCanceled)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при отмене сообщения"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Сообщение уже отправлено или отменено"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Запланированное сообщение отменено"})
+}
+
+// RunMessageScheduler periodically posts scheduled messages that are due.
+// Each one is claimed with a row lock and marked sent in the same
+// transaction that stores the message, so a restart never loses or
+// duplicates a message, and several instances can run side by side.
+func RunMessageScheduler(manager *services.WebSocketManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			posted, err := postDueScheduledMessage(manager)
+			if err != nil {
+				log.Printf("Error posting scheduled message: %v", err)
+				break
+			}
+			if !posted {
+				break
+			}
+		}
+	}
+}
+
+// postDueScheduledMessage handles the next due scheduled message, if any.
+// It reports whether it found one, so the caller can keep draining.
+func postDueScheduledMessage(manager *services.WebSocketManager) (bool, error) {
+	var (
+		scheduled models.ScheduledMessage
+		message   models.Message
+		room      models.Room
+		user      models.User
+		found     bool
+		posted    bool
+	)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND send_at <= ?", models.ScheduledStatusPending, time.Now()).
+			Order("send_at, id").
+			First(&scheduled).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+
+		fail := func(reason string) error {
+			scheduled.Status = models.ScheduledStatusFailed
+			scheduled.Error = reason
+			return tx.Model(&scheduled).Updates(map[string]interface{}{
+				"status": scheduled.Status,
+				"error":  reason,
+			}).Error
+		}
+
+		if err := tx.First(&room, scheduled.RoomID).Error; err != nil {
+			return fail("Комната не найдена")
+		}
+		if err := tx.First(&user, scheduled.UserID).Error; err != nil {
+			return fail("Пользователь не найден")
+		}
+		if !canViewRoom(&room, user.ID) {
+			return fail("У вас нет доступа к этой комнате")
+		}
+		if scheduled.ParentID != nil {
+			var parent models.Message
+			if err := tx.First(&parent, *scheduled.ParentID).Error; err != nil || parent.IsDeleted {
+				return fail("Родительское сообщение удалено")
+			}
+		}
+		if perr := checkPostingRules(&room, user.ID, false); perr != nil {
+			return fail(perr.Message)
+		}
+		if err := lockSlowMode(tx, &room, user.ID); err != nil {
+			var perr *PostingError
+			if !errors.As(err, &perr) {
+				return err
+			}
+			// Postpone until the slow mode interval has passed.
+			return tx.Model(&scheduled).
+				Update("send_at", time.Now().Add(time.Duration(perr.RetryAfter)*time.Second)).Error
+		}
+
+		now := time.Now()
+		message = models.Message{
+			Content:   unescapeCommand(scheduled.Content),
+			Format:    scheduled.Format,
+			UserID:    scheduled.UserID,
+			RoomID:    scheduled.RoomID,
+			ParentID:  scheduled.ParentID,
+			ExpiresAt: messageExpiry(&room, nil, now),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := insertMessage(tx, &message, nil); err != nil {
+			return err
+		}
+
+		scheduled.Status = models.ScheduledStatusSent
+		scheduled.MessageID = &message.ID
+		scheduled.SentAt = &now
+		posted = true
+		return tx.Model(&scheduled).Updates(map[string]interface{}{
+			"status":     scheduled.Status,
+			"message_id": message.ID,
+			"sent_at":    now,
+		}).Error
+	})
+	if err != nil || !found {
+		return false, err
+	}
+
+	if posted {
+		announceMessage(manager, &message, &room, user)
+	}
+	if scheduled.Status != models.ScheduledStatusPending {
+		notifyScheduledMessage(manager, &scheduled)
+	}
+	return true, nil
+}
+
+// notifyScheduledMessage tells the author's devices that a scheduled message
+// was sent or failed, so their schedule lists stay current.
+func notifyScheduledMessage(manager *services.WebSocketManager, scheduled *models.ScheduledMessage) {
+	event, err := json.Marshal(map[string]interface{}{
+		"type":              "scheduled_message_" + scheduled.Status,
+		"scheduled_message": scheduled,
+		"room_id":           scheduled.RoomID,
+		"timestamp":         time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return
+	}
+	manager.SendToUser(scheduled.UserID, event)
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	searchSnippetRunes = 200
+
+	// Highlight markers chosen so they cannot survive HTML escaping of the
+	// message text; they are swapped for <mark> tags after escaping.
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// messageSearchVector must match the expression of the full-text index
+// created in db.ConnectDatabase.
+const messageSearchVector = "to_tsvector('simple', messages.content_text)"
+
+type SearchResult struct {
+	Message models.Message `json:"message"`
+	Snippet string         `json:"snippet"`
+}
+
+type messageSearchQuery struct {
+	Terms   []string
+	From    []string
+	In      []string
+	Before  *time.Time
+	After   *time.Time
+	HasFile bool
+}
+
+// splitSearchQuery splits on whitespace, keeping double-quoted phrases
+// (including quoted filter values like in:"team chat") together.
+func splitSearchQuery(q string) []string {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", value, time.UTC)
+}
+
+// parseMessageSearchQuery understands from:user, in:room, before:YYYY-MM-DD,
+// after:YYYY-MM-DD and has:file; everything else is searched as text.
+func parseMessageSearchQuery(q string) (*messageSearchQuery, error) {
+	query := &messageSearchQuery{}
+	for _, token := range splitSearchQuery(q) {
+		key, value, found := strings.Cut(token, ":")
+		value = strings.Trim(value, `"`)
+		if !found || value == "" {
+			query.Terms = append(query.Terms, token)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			query.From = append(query.From, strings.ToLower(strings.TrimPrefix(value, "@")))
+		case "in":
+			query.In = append(query.In, strings.ToLower(strings.TrimPrefix(value, "#")))
+		case "before":
+			date, err := parseSearchDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("Неверная дата: %s", value)
+			}
+			query.Before = &date
+		case "after":
+			date, err := parseSearchDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("Неверная дата: %s", value)
+			}
+			// after: excludes the given day itself.
+			next := date.AddDate(0, 0, 1)
+			query.After = &next
+		case "has":
+			if strings.ToLower(value) != "file" {
+				return nil, fmt.Errorf("Неизвестный фильтр: has:%s", value)
+			}
+			query.HasFile = true
+		default:
+			query.Terms = append(query.Terms, token)
+		}
+	}
+	return query, nil
+}
+
+// highlightSnippet escapes a ts_headline fragment and turns its markers
+// into <mark> tags.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, highlightStart, "<mark>")
+	return strings.ReplaceAll(snippet, highlightStop, "</mark>")
+}
+
+func plainSnippet(text string) string {
+	text = strings.NewReplacer(highlightStart, "", highlightStop, "").Replace(text)
+	runes := []rune(text)
+	if len(runes) > searchSnippetRunes {
+		text = string(runes[:searchSnippetRunes]) + "…"
+	}
+	return html.EscapeString(text)
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likePrefix builds a case-insensitive LIKE pattern matching values that
+// start with q literally. Use it with ESCAPE '\'.
+func likePrefix(q string) string {
+	return likeEscaper.Replace(strings.ToLower(q)) + "%"
+}
+
+// SearchMessages runs a full-text search over messages in the current
+// workspace's rooms the caller belongs to, newest first. The q parameter
+// accepts plain words, "quoted phrases" and the filters from:user, from:me,
+// in:room, before:YYYY-MM-DD, after:YYYY-MM-DD and has:file. Snippets are
+// HTML-escaped with matches wrapped in <mark>. Pass the last result's
+// message ID as "before" to page.
+func SearchMessages(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	parsed, err := parseMessageSearchQuery(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(parsed.Terms) == 0 && len(parsed.From) == 0 && len(parsed.In) == 0 &&
+		parsed.Before == nil && parsed.After == nil && !parsed.HasFile {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Пустой поисковый запрос"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	query := db.DB.Table("messages").
+		Where("messages.deleted_at IS NULL AND messages.removed_at IS NULL").
+		Where("messages.expires_at IS NULL OR messages.expires_at > NOW()").
+		Where("messages.room_id IN (?)", memberRoomIDs(workspaceRooms(c), userID))
+
+	if before := c.Query("before"); before != "" {
+		beforeID, err := strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный курсор"})
+			return
+		}
+		query = query.Where("messages.id < ?", beforeID)
+	}
+
+	if len(parsed.From) > 0 {
+		usernames := make([]string, 0, len(parsed.From))
+		fromMe := false
+		for _, name := range parsed.From {
+			if name == "me" {
+				fromMe = true
+			} else {
+				usernames = append(usernames, name)
+			}
+		}
+		authors := db.DB.Model(&models.User{}).Select("id").Where("LOWER(username) IN ?", usernames)
+		if fromMe {
+			query = query.Where("messages.user_id = ? OR messages.user_id IN (?)", userID, authors)
+		} else {
+			query = query.Where("messages.user_id IN (?)", authors)
+		}
+	}
+	if len(parsed.In) > 0 {
+		query = query.Where("messages.room_id IN (?)",
+			workspaceRooms(c).Model(&models.Room{}).Select("id").Where("LOWER(name) IN ?", parsed.In))
+	}
+	if parsed.Before != nil {
+		query = query.Where("messages.created_at < ?", *parsed.Before)
+	}
+	if parsed.After != nil {
+		query = query.Where("messages.created_at >= ?", *parsed.After)
+	}
+	if parsed.HasFile {
+		query = query.Where("EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id)")
+	}
+
+	snippetExpr := "messages.content_text"
+	var snippetArgs []interface{}
+	if len(parsed.Terms) > 0 {
+		terms := strings.Join(parsed.Terms, " ")
+		query = query.Where(messageSearchVector+" @@ websearch_to_tsquery('simple', ?)", terms)
+		snippetExpr = "ts_headline('simple', messages.content_text, websearch_to_tsquery('simple', ?), ?)"
+		snippetArgs = []interface{}{
+			terms,
+			fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=5", highlightStart, highlightStop),
+		}
+	}
+
+	var hits []struct {
+		ID      uint
+		Snippet string
+	}
+	if err := query.Select("messages.id, "+snippetExpr+" AS snippet", snippetArgs...).
+		Order("messages.id DESC").
+		Limit(limit + 1).
+		Scan(&hits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при поиске сообщений"})
+		return
+	}
+
+	hasMore := len(hits) > limit
+	if hasMore {
+		hits = hits[:limit]
+	}
+
+	results := make([]SearchResult, 0, len(hits))
+	if len(hits) > 0 {
+		ids := make([]uint, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit.ID
+		}
+
+		var messages []models.Message
+		if err := withMessageContent(db.DB.Preload("Room")).Where("id IN ?", ids).Find(&messages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при поиске сообщений"})
+			return
+		}
+		if err := attachMentions(messages); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении упоминаний"})
+			return
+		}
+		byID := make(map[uint]models.Message, len(messages))
+		for _, m := range messages {
+			byID[m.ID] = m
+		}
+
+		for _, hit := range hits {
+			message, ok := byID[hit.ID]
+			if !ok {
+				continue
+			}
+			snippet := plainSnippet(hit.Snippet)
+			if len(parsed.Terms) > 0 {
+				snippet = highlightSnippet(hit.Snippet)
+			}
+			results = append(results, SearchResult{Message: message, Snippet: snippet})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":  results,
+		"has_more": hasMore,
+	})
+}
@@ -0,0 +1,18 @@
+package api
+
+import "testing"
+
+func TestLikePrefix(t *testing.T) {
+	tests := map[string]string{
+		"Alice":   "alice%",
+		"50%":     `50\%%`,
+		"a_b":     `a\_b%`,
+		`back\sl`: `back\\sl%`,
+		`%_\`:     `\%\_\\%`,
+	}
+	for q, want := range tests {
+		if got := likePrefix(q); got != want {
+			t.Errorf("likePrefix(%q) = %q, want %q", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+	"github.com/Kenzhe14/chat/services"
+	"github.com/Kenzhe14/chat/unfurl"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	linkPreviewTTL       = 24 * time.Hour
+	failedLinkPreviewTTL = time.Hour
+	linkFetchTimeout     = 5 * time.Second
+	linkFetchMaxBytes    = 512 << 10
+)
+
+var linkFetcher = unfurl.NewFetcher(linkFetchTimeout, linkFetchMaxBytes, false)
+
+// RunUnfurlWorker consumes new-message jobs from RabbitMQ, attaches link
+// previews to messages containing URLs and pushes a message_updated event
+// to the room.
+func RunUnfurlWorker(manager *services.WebSocketManager) error {
+	return services.ConsumeUnfurlJobs(func(job services.UnfurlJob) error {
+		if err := unfurlMessage(manager, job.MessageID); err != nil {
+			return fmt.Errorf("message %d: %w", job.MessageID, err)
+		}
+		return nil
+	})
+}
+
+// queueUnfurl schedules link previews for a message if it contains URLs.
+func queueUnfurl(message *models.Message) {
+	if len(unfurl.ExtractURLs(message.Content)) == 0 {
+		return
+	}
+	if err := services.PublishUnfurlJob(services.UnfurlJob{MessageID: message.ID}); err != nil {
+		log.Printf("Error queueing unfurl job for message %d: %v", message.ID, err)
+	}
+}
+
+func unfurlMessage(manager *services.WebSocketManager, messageID uint) error {
+	var message models.Message
+	err := db.DB.First(&message, messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if message.IsDeleted {
+		return nil
+	}
+
+	var previews []models.LinkPreview
+	for _, rawURL := range unfurl.ExtractURLs(message.Content) {
+		preview, err := cachedLinkPreview(rawURL)
+		if err != nil {
+			log.Printf("Error loading link preview for %s: %v", rawURL, err)
+			continue
+		}
+		if !preview.Failed {
+			previews = append(previews, *preview)
+		}
+	}
+	if len(previews) == 0 {
+		return nil
+	}
+
+	if err := db.DB.Model(&message).Association("LinkPreviews").Replace(previews); err != nil {
+		return err
+	}
+
+	if err := withMessageContent(db.DB).First(&message, message.ID).Error; err != nil {
+		return err
+	}
+	if message.IsDeleted {
+		return nil
+	}
+
+	broadcastRoomEvent(manager, message.RoomID, "message_updated", map[string]interface{}{
+		"message_id": message.ID,
+		"parent_id":  message.ParentID,
+		"message":    message,
+	})
+	return nil
+}
+
+// cachedLinkPreview returns the stored preview for rawURL, fetching it again
+// once the cached entry has expired.
+func cachedLinkPreview(rawURL string) (*models.LinkPreview, error) {
+	var cached models.LinkPreview
+	err := db.DB.Where("url = ?", rawURL).First(&cached).Error
+	if err == nil {
+		ttl := linkPreviewTTL
+		if cached.Failed {
+			ttl = failedLinkPreviewTTL
+		}
+		if time.Since(cached.FetchedAt) < ttl {
+			return &cached, nil
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*linkFetchTimeout)
+	defer cancel()
+
+	preview := models.LinkPreview{URL: rawURL, FetchedAt: time.Now()}
+	result, err := linkFetcher.Fetch(ctx, rawURL)
+	if err != nil {
+		log.Printf("Error unfurling %s: %v", rawURL, err)
+		preview.Failed = true
+	} else {
+		preview.Title = result.Title
+		preview.Description = result.Description
+		preview.SiteName = result.SiteName
+		preview.ImageURL = result.ImageURL
+	}
+
+	err = db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title", "description", "site_name", "image_url", "failed", "fetched_at"}),
+	}).Create(&preview).Error
+	if err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kenzhe14/chat/db"
+	"github.com/Kenzhe14/chat/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+	Slug string `json:"slug" binding:"required,alphanum,max=64"`
+}
+
+type AddWorkspaceMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+type UpdateWorkspaceMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
+}
+
+type WorkspaceUserResponse struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Avatar   string `json:"avatar"`
+	Status   string `json:"status"`
+	Role     string `json:"role"`
+}
+
+const (
+	defaultDirectoryLimit = 50
+	maxDirectoryLimit     = 200
+)
+
+// loadWorkspaceForUser loads the workspace from the :id parameter and returns
+// the caller's role in it. It writes the error response itself.
+func loadWorkspaceForUser(c *gin.Context) (models.Workspace, string, bool) {
+	var workspace models.Workspace
+
+	workspaceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID рабочего пространства"})
+		return workspace, "", false
+	}
+
+	if err := db.DB.First(&workspace, workspaceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Рабочее пространство не найдено"})
+		return workspace, "", false
+	}
+
+	role := getWorkspaceRole(workspace.ID, c.GetUint("user_id"))
+	if role == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Вы не являетесь участником этого рабочего пространства"})
+		return workspace, "", false
+	}
+
+	return workspace, role, true
+}
+
+func GetWorkspaces(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var workspaceIDs []uint
+	if err := db.DB.Model(&models.WorkspaceMember{}).Where("user_id = ?", userID).Pluck("workspace_id", &workspaceIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении рабочих пространств"})
+		return
+	}
+	workspaceIDs = append(workspaceIDs, db.DefaultWorkspaceID)
+
+	var workspaces []models.Workspace
+	if err := db.DB.Where("id IN ?", workspaceIDs).Order("id").Find(&workspaces).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении рабочих пространств"})
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+func CreateWorkspace(c *gin.Context) {
+	var req CreateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	slug := strings.ToLower(req.Slug)
+
+	var existing models.Workspace
+	if err := db.DB.Where("slug = ?", slug).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Рабочее пространство с таким адресом уже существует"})
+		return
+	}
+
+	workspace := models.Workspace{
+		Name:      req.Name,
+		Slug:      slug,
+		OwnerID:   userID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      userID,
+			Role:        models.WorkspaceRoleOwner,
+			JoinedAt:    time.Now(),
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при создании рабочего пространства: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, workspace)
+}
+
+// GetWorkspaceUsers lists the workspace's user directory, optionally filtered
+// by a username or email prefix.
+func GetWorkspaceUsers(c *gin.Context) {
+	workspace, _, ok := loadWorkspaceForUser(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDirectoryLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный лимит"})
+		return
+	}
+	if limit > maxDirectoryLimit {
+		limit = maxDirectoryLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверное смещение"})
+		return
+	}
+
+	query := db.DB.Table("users").
+		Joins("LEFT JOIN workspace_members wm ON wm.user_id = users.id AND wm.workspace_id = ?", workspace.ID).
+		Where("users.deleted_at IS NULL")
+	if workspace.ID != db.DefaultWorkspaceID {
+		query = query.Where("wm.user_id IS NOT NULL")
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := likePrefix(q)
+		query = query.Where(`LOWER(users.username) LIKE ? ESCAPE '\' OR LOWER(users.email) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении пользователей"})
+		return
+	}
+
+	users := []WorkspaceUserResponse{}
+	if err := query.Select("users.id, users.username, users.email, users.avatar, users.status, COALESCE(wm.role, ?) AS role", models.WorkspaceRoleMember).
+		Order("users.username").
+		Limit(limit).
+		Offset(offset).
+		Scan(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при получении пользователей"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"total": total,
+	})
+}
+
+func AddWorkspaceMember(c *gin.Context) {
+	workspace, role, ok := loadWorkspaceForUser(c)
+	if !ok {
+		return
+	}
+
+	var req AddWorkspaceMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !(models.WorkspaceMember{Role: role}).IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Только администраторы могут добавлять участников"})
+		return
+	}
+
+	var user models.User
+	if err := db.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь с указанным email не найден"})
+		return
+	}
+
+	var existing models.WorkspaceMember
+	if err := db.DB.Where("workspace_id = ? AND user_id = ?", workspace.ID, user.ID).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Пользователь уже является участником рабочего пространства"})
+		return
+	}
+
+	member := models.WorkspaceMember{
+		WorkspaceID: workspace.ID,
+		UserID:      user.ID,
+		Role:        req.Role,
+		JoinedAt:    time.Now(),
+	}
+	if member.Role == "" {
+		member.Role = models.WorkspaceRoleMember
+	}
+
+	if err := db.DB.Create(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при добавлении участника"})
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+func UpdateWorkspaceMemberRole(c *gin.Context) {
+	workspace, role, ok := loadWorkspaceForUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return
+	}
+
+	var req UpdateWorkspaceMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if role != models.WorkspaceRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Только владелец может изменять роли"})
+		return
+	}
+
+	if uint(userID) == workspace.OwnerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Нельзя изменить роль владельца рабочего пространства"})
+		return
+	}
+
+	result := db.DB.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id = ?", workspace.ID, userID).
+		Update("role", req.Role)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при изменении роли участника"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не является участником рабочего пространства"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workspace_id": workspace.ID, "user_id": userID, "role": req.Role})
+}
+
+func RemoveWorkspaceMember(c *gin.Context) {
+	workspace, role, ok := loadWorkspaceForUser(c)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return
+	}
+
+	removerID := c.GetUint("user_id")
+	if !(models.WorkspaceMember{Role: role}).IsAdmin() && uint(userID) != removerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет прав на удаление этого участника"})
+		return
+	}
+
+	if uint(userID) == workspace.OwnerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Нельзя удалить владельца рабочего пространства"})
+		return
+	}
+
+	// Only the owner can remove other admins.
+	if uint(userID) != removerID && removerID != workspace.OwnerID &&
+		(models.WorkspaceMember{Role: getWorkspaceRole(workspace.ID, uint(userID))}).IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "У вас нет прав на удаление этого участника"})
+		return
+	}
+
+	if workspace.ID == db.DefaultWorkspaceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя покинуть рабочее пространство по умолчанию"})
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workspace_id = ? AND user_id = ?", workspace.ID, userID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND room_id IN (?)", userID,
+			tx.Model(&models.Room{}).Select("id").Where("workspace_id = ?", workspace.ID)).
+			Delete(&models.RoomMember{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка при удалении участника"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Участник успешно удален из рабочего пространства"})
+}
@@ -13,6 +13,10 @@ import (
 
 var DB *gorm.DB
 
+// DefaultWorkspaceID is the ID of the workspace used when a request does not
+// name one.
+var DefaultWorkspaceID uint
+
 func ConnectDatabase() {
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
@@ -31,14 +35,49 @@ func ConnectDatabase() {
 
 	log.Println("Database connected successfully")
 
-	err = DB.AutoMigrate(&models.User{}, &models.Room{}, &models.Message{}, &models.RoomMember{})
+	err = DB.AutoMigrate(&models.User{}, &models.Workspace{}, &models.WorkspaceMember{},
+		&models.Room{}, &models.RoomMember{}, &models.RoomAuditEntry{},
+		&models.Message{}, &models.MessageRevision{}, &models.MessageReaction{},
+		&models.MessageMention{}, &models.Notification{}, &models.Attachment{}, &models.LinkPreview{},
+		&models.ScheduledMessage{}, &models.PinnedMessage{},
+		&models.Poll{}, &models.PollOption{}, &models.PollVote{}, &models.Bookmark{},
+		&models.Reminder{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	if err := ensureDefaultWorkspace(); err != nil {
+		log.Fatalf("Failed to prepare default workspace: %v", err)
+	}
+
+	// Messages written before formats existed are plain text.
+	if err := DB.Model(&models.Message{}).Where("content_text IS NULL").
+		UpdateColumn("content_text", gorm.Expr("content")).Error; err != nil {
+		log.Fatalf("Failed to backfill message text: %v", err)
+	}
+
+	if err := DB.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_search
+		ON messages USING GIN (to_tsvector('simple', content_text))`).Error; err != nil {
+		log.Fatalf("Failed to create message search index: %v", err)
+	}
+
 	log.Println("Database migration completed")
 }
 
+// ensureDefaultWorkspace creates the default workspace if needed and moves
+// rooms without a workspace into it.
+func ensureDefaultWorkspace() error {
+	workspace := models.Workspace{Name: "Default", Slug: models.DefaultWorkspaceSlug}
+	if err := DB.Where("slug = ?", workspace.Slug).FirstOrCreate(&workspace).Error; err != nil {
+		return err
+	}
+	DefaultWorkspaceID = workspace.ID
+
+	return DB.Model(&models.Room{}).
+		Where("workspace_id IS NULL OR workspace_id = 0").
+		Update("workspace_id", workspace.ID).Error
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -13,6 +13,7 @@ import (
 	"github.com/Kenzhe14/chat/api"
 	"github.com/Kenzhe14/chat/db"
 	"github.com/Kenzhe14/chat/services"
+	"github.com/Kenzhe14/chat/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,9 +24,25 @@ func main() {
 	services.InitRabbitMQ()
 	defer services.CloseRabbitMQ()
 
+	storage.InitStorage()
+
 	wsManager := services.NewWebSocketManager()
+	wsManager.AddFilter(api.WebSocketMessageFilter(wsManager))
+	wsManager.SetTypingGuard(api.CanTypeInRoom)
 	go wsManager.Start()
 
+	go api.RunMessagePurger(time.Minute)
+	go api.RunMessageScheduler(wsManager, 5*time.Second)
+	go api.RunExpiredMessageSweeper(wsManager, 10*time.Second)
+	go api.RunReminders(wsManager, 30*time.Second)
+
+	if err := api.RunMediaWorker(wsManager); err != nil {
+		log.Printf("Failed to start media worker: %v", err)
+	}
+	if err := api.RunUnfurlWorker(wsManager); err != nil {
+		log.Printf("Failed to start unfurl worker: %v", err)
+	}
+
 	services.ConsumeMessages(func(msg services.MessageEvent) {
 		log.Printf("Received message: %s from %s in room %d", msg.Content, msg.Username, msg.RoomID)
 	})
@@ -48,7 +65,46 @@ func main() {
 		{
 			authorized.POST("/auth/logout", api.LogoutUser)
 
+			notificationRoutes := authorized.Group("/notifications")
+			{
+				notificationRoutes.GET("", api.GetNotifications)
+				notificationRoutes.POST("/read-all", api.MarkAllNotificationsRead)
+				notificationRoutes.POST("/:id/read", api.MarkNotificationRead)
+			}
+
+			scheduledRoutes := authorized.Group("/scheduled-messages")
+			scheduledRoutes.Use(api.WorkspaceMiddleware())
+			{
+				scheduledRoutes.GET("", api.GetScheduledMessages)
+				scheduledRoutes.POST("", api.ScheduleMessage)
+				scheduledRoutes.PATCH("/:id", api.UpdateScheduledMessage)
+				scheduledRoutes.DELETE("/:id", api.CancelScheduledMessage)
+			}
+
+			searchRoutes := authorized.Group("/search")
+			searchRoutes.Use(api.WorkspaceMiddleware())
+			{
+				searchRoutes.GET("/messages", api.SearchMessages)
+			}
+
+			bookmarkRoutes := authorized.Group("/bookmarks")
+			bookmarkRoutes.Use(api.WorkspaceMiddleware())
+			{
+				bookmarkRoutes.GET("", api.GetBookmarks)
+			}
+
+			workspaceRoutes := authorized.Group("/workspaces")
+			{
+				workspaceRoutes.GET("", api.GetWorkspaces)
+				workspaceRoutes.POST("", api.CreateWorkspace)
+				workspaceRoutes.GET("/:id/users", api.GetWorkspaceUsers)
+				workspaceRoutes.POST("/:id/members", api.AddWorkspaceMember)
+				workspaceRoutes.PUT("/:id/members/:user_id/role", api.UpdateWorkspaceMemberRole)
+				workspaceRoutes.DELETE("/:id/members/:user_id", api.RemoveWorkspaceMember)
+			}
+
 			roomRoutes := authorized.Group("/rooms")
+			roomRoutes.Use(api.WorkspaceMiddleware())
 			{
 				roomRoutes.GET("", api.GetRooms)
 				roomRoutes.GET("/user", api.GetUserRooms)
@@ -56,16 +112,43 @@ func main() {
 				roomRoutes.POST("", api.CreateRoom)
 				roomRoutes.PUT("/:id", api.UpdateRoom)
 				roomRoutes.DELETE("/:id", api.DeleteRoom)
+				roomRoutes.GET("/:id/audit", api.GetRoomAudit)
+				roomRoutes.POST("/:id/read", api.MarkRoomRead(wsManager))
+				roomRoutes.POST("/:id/attachments", api.UploadAttachment)
+				roomRoutes.GET("/:id/pins", api.GetRoomPins)
 
-				roomRoutes.GET("/:id/members", api.GetRoomMembers)
+				roomRoutes.GET("/:id/members", api.GetRoomMembers(wsManager))
 				roomRoutes.POST("/:id/members", api.AddRoomMember)
 				roomRoutes.DELETE("/:id/members/:user_id", api.RemoveRoomMember)
+				roomRoutes.PUT("/:id/members/:user_id/role", api.UpdateRoomMemberRole)
 			}
 
 			msgRoutes := authorized.Group("/messages")
+			msgRoutes.Use(api.WorkspaceMiddleware())
 			{
 				msgRoutes.GET("/room/:room_id", api.GetMessages)
-				msgRoutes.POST("", api.CreateMessage)
+				msgRoutes.GET("/:id/thread", api.GetThread)
+				msgRoutes.GET("/:id/history", api.GetMessageHistory)
+				msgRoutes.POST("", api.CreateMessage(wsManager))
+				msgRoutes.PATCH("/:id", api.UpdateMessage(wsManager))
+				msgRoutes.DELETE("/:id", api.DeleteMessage(wsManager))
+				msgRoutes.POST("/:id/restore", api.RestoreMessage(wsManager))
+				msgRoutes.POST("/:id/reactions", api.AddReaction(wsManager))
+				msgRoutes.DELETE("/:id/reactions/:emoji", api.RemoveReaction(wsManager))
+				msgRoutes.POST("/:id/pin", api.PinMessage(wsManager))
+				msgRoutes.DELETE("/:id/pin", api.UnpinMessage(wsManager))
+				msgRoutes.POST("/:id/poll/votes", api.VotePoll(wsManager))
+				msgRoutes.DELETE("/:id/poll/votes", api.RetractPollVote(wsManager))
+				msgRoutes.POST("/:id/poll/close", api.ClosePoll(wsManager))
+				msgRoutes.PUT("/:id/bookmark", api.BookmarkMessage)
+				msgRoutes.DELETE("/:id/bookmark", api.RemoveBookmark)
+			}
+
+			attachmentRoutes := authorized.Group("/attachments")
+			attachmentRoutes.Use(api.WorkspaceMiddleware())
+			{
+				attachmentRoutes.GET("/:id", api.DownloadAttachment)
+				attachmentRoutes.GET("/:id/thumbnail", api.DownloadThumbnail)
 			}
 		}
 
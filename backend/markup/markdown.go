@@ -0,0 +1,384 @@
+// Package markup parses the Markdown subset supported in chat messages.
+//
+// Messages are parsed into a small tree of allowlisted node kinds, so the
+// rendered HTML can only ever contain the elements produced here: raw HTML
+// in the source is always escaped, and links are limited to safe schemes.
+package markup
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Node kinds. Nothing outside this list is ever rendered.
+const (
+	KindDocument   = "document"
+	KindParagraph  = "paragraph"
+	KindCodeBlock  = "code_block"
+	KindBlockquote = "blockquote"
+	KindList       = "list"
+	KindListItem   = "list_item"
+	KindText       = "text"
+	KindStrong     = "strong"
+	KindEmphasis   = "emphasis"
+	KindStrike     = "strike"
+	KindCode       = "code"
+	KindLink       = "link"
+	KindLineBreak  = "line_break"
+)
+
+// Node is an element of a parsed message.
+type Node struct {
+	Kind     string  `json:"kind"`
+	Text     string  `json:"text,omitempty"`
+	Language string  `json:"language,omitempty"`
+	Href     string  `json:"href,omitempty"`
+	Ordered  bool    `json:"ordered,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+var (
+	fencePattern       = regexp.MustCompile("^\\s*```\\s*([A-Za-z0-9_+#.-]*)\\s*$")
+	closeFencePattern  = regexp.MustCompile("^\\s*```\\s*$")
+	bulletPattern      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	orderedItemPattern = regexp.MustCompile(`^\s*\d{1,9}[.)]\s+(.*)$`)
+	quotePattern       = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	autolinkPattern    = regexp.MustCompile(`^https?://[^\s<>"'` + "`" + `]+`)
+)
+
+// Parse turns Markdown source into a node tree.
+func Parse(source string) *Node {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	return &Node{Kind: KindDocument, Children: parseBlocks(strings.Split(source, "\n"), 0)}
+}
+
+// maxQuoteDepth limits how deeply blockquotes may nest. Deeper markers are
+// kept as text.
+const maxQuoteDepth = 8
+
+func parseBlocks(lines []string, depth int) []*Node {
+	var blocks []*Node
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, &Node{Kind: KindParagraph, Children: parseInlineLines(paragraph)})
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			flush()
+			var code []string
+			i++
+			for ; i < len(lines) && !closeFencePattern.MatchString(lines[i]); i++ {
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, &Node{
+				Kind:     KindCodeBlock,
+				Language: strings.ToLower(m[1]),
+				Text:     strings.Join(code, "\n"),
+			})
+			continue
+		}
+
+		if depth < maxQuoteDepth && quotePattern.MatchString(line) {
+			flush()
+			var quoted []string
+			for ; i < len(lines); i++ {
+				m := quotePattern.FindStringSubmatch(lines[i])
+				if m == nil {
+					break
+				}
+				quoted = append(quoted, m[1])
+			}
+			i--
+			blocks = append(blocks, &Node{Kind: KindBlockquote, Children: parseBlocks(quoted, depth+1)})
+			continue
+		}
+
+		if bulletPattern.MatchString(line) || orderedItemPattern.MatchString(line) {
+			flush()
+			ordered := orderedItemPattern.MatchString(line)
+			pattern := bulletPattern
+			if ordered {
+				pattern = orderedItemPattern
+			}
+			list := &Node{Kind: KindList, Ordered: ordered}
+			for ; i < len(lines); i++ {
+				m := pattern.FindStringSubmatch(lines[i])
+				if m == nil {
+					break
+				}
+				list.Children = append(list.Children, &Node{Kind: KindListItem, Children: parseInline(m[1], 0)})
+			}
+			i--
+			blocks = append(blocks, list)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+
+	return blocks
+}
+
+func parseInlineLines(lines []string) []*Node {
+	var nodes []*Node
+	for i, line := range lines {
+		if i > 0 {
+			nodes = append(nodes, &Node{Kind: KindLineBreak})
+		}
+		nodes = append(nodes, parseInline(strings.TrimSpace(line), 0)...)
+	}
+	return nodes
+}
+
+// maxInlineDepth limits how deeply emphasis and links may nest. Deeper
+// markers are kept as text.
+const maxInlineDepth = 8
+
+// maxAutolinkLength caps the length of bare URLs turned into links.
+const maxAutolinkLength = 2048
+
+// inlineDelimiters pairs emphasis markers with the node kind they produce,
+// longest markers first.
+var inlineDelimiters = []struct {
+	marker string
+	kind   string
+}{
+	{"**", KindStrong},
+	{"__", KindStrong},
+	{"~~", KindStrike},
+	{"*", KindEmphasis},
+	{"_", KindEmphasis},
+}
+
+// finder returns the first position at or after a given one where match
+// holds. The parser only ever asks for increasing positions, so the last
+// answer is reused until it falls behind; together with remembering that
+// nothing matches past a point, this keeps a whole line linear no matter
+// how many openers lack a closer.
+type finder struct {
+	text  string
+	match func(text string, pos int) bool
+	from  int
+	next  int
+}
+
+func newFinder(text string, match func(text string, pos int) bool) *finder {
+	return &finder{text: text, match: match, from: -1}
+}
+
+func (f *finder) find(pos int) int {
+	if f.from >= 0 && pos >= f.from && (f.next < 0 || f.next >= pos) {
+		return f.next
+	}
+	f.from, f.next = pos, -1
+	for p := pos; p < len(f.text); p++ {
+		if f.match(f.text, p) {
+			f.next = p
+			break
+		}
+	}
+	return f.next
+}
+
+func byteFinder(text string, b byte) *finder {
+	return newFinder(text, func(text string, pos int) bool { return text[pos] == b })
+}
+
+// closerFinder finds where a run of emphasis can close: the marker must
+// follow a non-space character, and an underscore must not be followed by a
+// word character.
+func closerFinder(text, marker string) *finder {
+	return newFinder(text, func(text string, pos int) bool {
+		if pos == 0 || !strings.HasPrefix(text[pos:], marker) || text[pos-1] == ' ' {
+			return false
+		}
+		after := pos + len(marker)
+		return marker[0] != '_' || after >= len(text) || !isWordByte(text[after])
+	})
+}
+
+// inlineParser parses the inline content of one line.
+type inlineParser struct {
+	text      string
+	depth     int
+	backticks *finder
+	labelEnds *finder
+	hrefEnds  *finder
+	closers   map[string]*finder
+}
+
+func parseInline(text string, depth int) []*Node {
+	p := &inlineParser{
+		text:      text,
+		depth:     depth,
+		backticks: byteFinder(text, '`'),
+		labelEnds: newFinder(text, func(text string, pos int) bool { return strings.HasPrefix(text[pos:], "](") }),
+		hrefEnds:  byteFinder(text, ')'),
+		closers:   make(map[string]*finder, len(inlineDelimiters)),
+	}
+	for _, d := range inlineDelimiters {
+		p.closers[d.marker] = closerFinder(text, d.marker)
+	}
+	return p.parse()
+}
+
+func (p *inlineParser) parse() []*Node {
+	text := p.text
+	var nodes []*Node
+	var plain strings.Builder
+
+	flush := func() {
+		if plain.Len() > 0 {
+			nodes = append(nodes, &Node{Kind: KindText, Text: plain.String()})
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		if rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_~[]()>#+-.!", rune(rest[1])) {
+			plain.WriteByte(rest[1])
+			i += 2
+			continue
+		}
+
+		if rest[0] == '`' {
+			if end := p.backticks.find(i + 1); end > i+1 {
+				flush()
+				nodes = append(nodes, &Node{Kind: KindCode, Text: text[i+1 : end]})
+				i = end + 1
+				continue
+			}
+		}
+
+		if rest[0] == '[' && p.depth < maxInlineDepth {
+			if label, href, end, ok := p.parseLink(i); ok {
+				flush()
+				nodes = append(nodes, &Node{Kind: KindLink, Href: href, Children: parseInline(label, p.depth+1)})
+				i = end
+				continue
+			}
+		}
+
+		if m := p.autolink(i); m != "" {
+			m = strings.TrimRight(m, ".,;:!?)]}")
+			if href, ok := safeHref(m); ok {
+				flush()
+				nodes = append(nodes, &Node{Kind: KindLink, Href: href, Children: []*Node{{Kind: KindText, Text: m}}})
+				i += len(m)
+				continue
+			}
+		}
+
+		if p.depth < maxInlineDepth {
+			if matched, end := p.parseDelimited(i); matched != nil {
+				flush()
+				nodes = append(nodes, matched)
+				i = end
+				continue
+			}
+		}
+
+		plain.WriteByte(rest[0])
+		i++
+	}
+	flush()
+
+	return nodes
+}
+
+// autolink returns the bare URL starting at text[i], if any. URLs are
+// looked for only at word boundaries and are capped in length, so a line of
+// URL-like text cannot make the scan quadratic.
+func (p *inlineParser) autolink(i int) string {
+	rest := p.text[i:]
+	if !strings.HasPrefix(rest, "http") || (i > 0 && isWordByte(p.text[i-1])) {
+		return ""
+	}
+	if len(rest) > maxAutolinkLength {
+		rest = rest[:maxAutolinkLength]
+	}
+	return autolinkPattern.FindString(rest)
+}
+
+// parseDelimited recognises emphasis starting at text[i] and returns the
+// node and the position after its closing marker. Markers must open before
+// and close after a non-space character, and underscores only count at
+// word boundaries so snake_case identifiers stay intact.
+func (p *inlineParser) parseDelimited(i int) (*Node, int) {
+	text := p.text
+	for _, d := range inlineDelimiters {
+		if !strings.HasPrefix(text[i:], d.marker) {
+			continue
+		}
+		start := i + len(d.marker)
+		if start >= len(text) || text[start] == ' ' {
+			return nil, 0
+		}
+		if d.marker[0] == '_' && i > 0 && isWordByte(text[i-1]) {
+			return nil, 0
+		}
+
+		end := p.closers[d.marker].find(start + 1)
+		if end < 0 {
+			return nil, 0
+		}
+		return &Node{Kind: d.kind, Children: parseInline(text[start:end], p.depth+1)}, end + len(d.marker)
+	}
+	return nil, 0
+}
+
+// parseLink parses "[label](href)" at text[i] and returns the position
+// after it.
+func (p *inlineParser) parseLink(i int) (label, href string, end int, ok bool) {
+	closeLabel := p.labelEnds.find(i + 1)
+	if closeLabel < 0 {
+		return "", "", 0, false
+	}
+	closeHref := p.hrefEnds.find(closeLabel + 2)
+	if closeHref <= closeLabel+2 {
+		return "", "", 0, false
+	}
+	href, ok = safeHref(strings.TrimSpace(p.text[closeLabel+2 : closeHref]))
+	if !ok {
+		return "", "", 0, false
+	}
+	return p.text[i+1 : closeLabel], href, closeHref + 1, true
+}
+
+// safeHref accepts only absolute http, https and mailto links.
+func safeHref(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= 0x80 || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+}
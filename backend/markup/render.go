@@ -0,0 +1,114 @@
+package markup
+
+import (
+	"html"
+	"strings"
+)
+
+// HTML renders a node tree. Every text value is escaped; the only tags
+// emitted are those mapped from node kinds below.
+func HTML(node *Node) string {
+	var b strings.Builder
+	writeHTML(&b, node)
+	return b.String()
+}
+
+func writeHTML(b *strings.Builder, node *Node) {
+	writeChildren := func() {
+		for _, child := range node.Children {
+			writeHTML(b, child)
+		}
+	}
+	wrap := func(tag string) {
+		b.WriteString("<" + tag + ">")
+		writeChildren()
+		b.WriteString("</" + tag + ">")
+	}
+
+	switch node.Kind {
+	case KindDocument:
+		writeChildren()
+	case KindParagraph:
+		wrap("p")
+	case KindBlockquote:
+		wrap("blockquote")
+	case KindList:
+		if node.Ordered {
+			wrap("ol")
+		} else {
+			wrap("ul")
+		}
+	case KindListItem:
+		wrap("li")
+	case KindStrong:
+		wrap("strong")
+	case KindEmphasis:
+		wrap("em")
+	case KindStrike:
+		wrap("del")
+	case KindCodeBlock:
+		b.WriteString("<pre><code")
+		if node.Language != "" {
+			b.WriteString(` class="language-` + html.EscapeString(node.Language) + `"`)
+		}
+		b.WriteString(">" + html.EscapeString(node.Text) + "</code></pre>")
+	case KindCode:
+		b.WriteString("<code>" + html.EscapeString(node.Text) + "</code>")
+	case KindLink:
+		b.WriteString(`<a href="` + html.EscapeString(node.Href) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+		writeChildren()
+		b.WriteString("</a>")
+	case KindLineBreak:
+		b.WriteString("<br>")
+	case KindText:
+		b.WriteString(html.EscapeString(node.Text))
+	}
+}
+
+// Text renders a node tree as plain text, for notifications and search.
+func Text(node *Node) string {
+	var b strings.Builder
+	writeText(&b, node)
+	return strings.TrimSpace(b.String())
+}
+
+func writeText(b *strings.Builder, node *Node) {
+	switch node.Kind {
+	case KindText, KindCode:
+		b.WriteString(node.Text)
+	case KindCodeBlock:
+		b.WriteString(node.Text + "\n")
+	case KindLineBreak:
+		b.WriteString("\n")
+	case KindLink:
+		start := b.Len()
+		for _, child := range node.Children {
+			writeText(b, child)
+		}
+		if label := b.String()[start:]; label != node.Href && strings.TrimPrefix(node.Href, "mailto:") != label {
+			b.WriteString(" (" + node.Href + ")")
+		}
+	case KindListItem:
+		b.WriteString("- ")
+		for _, child := range node.Children {
+			writeText(b, child)
+		}
+		b.WriteString("\n")
+	case KindParagraph, KindBlockquote:
+		for _, child := range node.Children {
+			writeText(b, child)
+		}
+		b.WriteString("\n")
+	default:
+		for _, child := range node.Children {
+			writeText(b, child)
+		}
+	}
+}
+
+// Render returns the sanitized HTML and the plain-text projection of a
+// Markdown message body.
+func Render(source string) (htmlContent, text string) {
+	doc := Parse(source)
+	return HTML(doc), Text(doc)
+}
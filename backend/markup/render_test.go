@@ -0,0 +1,170 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderHTML(t *testing.T) {
+	const attrs = ` rel="nofollow noopener noreferrer" target="_blank"`
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "raw html is escaped",
+			source: "<script>alert(1)</script>",
+			want:   "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		},
+		{
+			name:   "attributes cannot be injected",
+			source: `<img src=x onerror="alert(1)">`,
+			want:   "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>",
+		},
+		{
+			name:   "javascript link is not rendered",
+			source: "[x](javascript:alert(1))",
+			want:   "<p>[x](javascript:alert(1))</p>",
+		},
+		{
+			name:   "javascript scheme is case-insensitive",
+			source: "[x](JaVaScRiPt:alert(1))",
+			want:   "<p>[x](JaVaScRiPt:alert(1))</p>",
+		},
+		{
+			name:   "data link is not rendered",
+			source: "[x](data:text/html;base64,PHNjcmlwdD4=)",
+			want:   "<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>",
+		},
+		{
+			name:   "relative link is not rendered",
+			source: "[x](/admin)",
+			want:   "<p>[x](/admin)</p>",
+		},
+		{
+			name:   "href is escaped",
+			source: `[x](https://example.com/?a="b"&c=<d>)`,
+			want:   `<p><a href="https://example.com/?a=&#34;b&#34;&amp;c=&lt;d&gt;"` + attrs + `>x</a></p>`,
+		},
+		{
+			name:   "link label is escaped",
+			source: "[<b>x</b>](https://example.com)",
+			want:   `<p><a href="https://example.com"` + attrs + `>&lt;b&gt;x&lt;/b&gt;</a></p>`,
+		},
+		{
+			name:   "mailto link",
+			source: "[mail](mailto:a@example.com)",
+			want:   `<p><a href="mailto:a@example.com"` + attrs + `>mail</a></p>`,
+		},
+		{
+			name:   "bare url",
+			source: "see https://example.com/path.",
+			want:   `<p>see <a href="https://example.com/path"` + attrs + `>https://example.com/path</a>.</p>`,
+		},
+		{
+			name:   "nested markup",
+			source: "**bold _em_ ~~gone~~**",
+			want:   "<p><strong>bold <em>em</em> <del>gone</del></strong></p>",
+		},
+		{
+			name:   "markup inside link label",
+			source: "[*<i>*](https://example.com)",
+			want:   `<p><a href="https://example.com"` + attrs + `><em>&lt;i&gt;</em></a></p>`,
+		},
+		{
+			name:   "inline code is escaped and not parsed",
+			source: "`<b>*x*</b>`",
+			want:   "<p><code>&lt;b&gt;*x*&lt;/b&gt;</code></p>",
+		},
+		{
+			name:   "code block is escaped",
+			source: "```js\n<img src=x onerror=alert(1)>\n```",
+			want:   `<pre><code class="language-js">&lt;img src=x onerror=alert(1)&gt;</code></pre>`,
+		},
+		{
+			name:   "snake_case stays intact",
+			source: "call snake_case_name now",
+			want:   "<p>call snake_case_name now</p>",
+		},
+		{
+			name:   "unclosed markers stay text",
+			source: "*a ~~c `d [e",
+			want:   "<p>*a ~~c `d [e</p>",
+		},
+		{
+			name:   "escaped markers",
+			source: `\*not em\*`,
+			want:   "<p>*not em*</p>",
+		},
+		{
+			name:   "quote and list",
+			source: "> quoted *x*\n- a\n- b",
+			want:   "<blockquote><p>quoted <em>x</em></p></blockquote><ul><li>a</li><li>b</li></ul>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := Render(tt.source)
+			if got != tt.want {
+				t.Errorf("Render(%q)\n got %s\nwant %s", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"**bold** and _em_", "bold and em"},
+		{"[docs](https://example.com)", "docs (https://example.com)"},
+		{"https://example.com", "https://example.com"},
+		{"- a\n- b", "- a\n- b"},
+		{"<b>x</b>", "<b>x</b>"},
+	}
+
+	for _, tt := range tests {
+		if _, got := Render(tt.source); got != tt.want {
+			t.Errorf("Render(%q) text = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestRenderDeepNestingIsCapped(t *testing.T) {
+	nodes := parseInline("*x* [y](mailto:a@example.com) **z**", maxInlineDepth)
+	if len(nodes) != 1 || nodes[0].Kind != KindText {
+		t.Errorf("markup parsed past the depth limit: %+v", nodes)
+	}
+
+	html, _ := Render(strings.Repeat(">", 100) + " x")
+	if strings.Count(html, "<blockquote>") > maxQuoteDepth {
+		t.Errorf("quote nesting not capped: %s", html)
+	}
+}
+
+// Inputs full of openers without closers used to take quadratic time.
+func TestRenderPathologicalInputIsFast(t *testing.T) {
+	inputs := map[string]string{
+		"emphasis":     strings.Repeat("*a ", 16000),
+		"underscore":   strings.Repeat("_a ", 16000),
+		"strong":       strings.Repeat("**a ", 12000),
+		"code":         strings.Repeat("`a ", 16000),
+		"link label":   strings.Repeat("[a ", 16000),
+		"link href":    strings.Repeat("[a](", 12000),
+		"autolink":     strings.Repeat("(http://", 6000),
+		"nested quote": strings.Repeat(">", 40000),
+	}
+
+	for name, source := range inputs {
+		start := time.Now()
+		Render(source)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: rendering %d bytes took %v", name, len(source), elapsed)
+		}
+	}
+}
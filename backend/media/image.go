@@ -0,0 +1,136 @@
+// Package media extracts previews and metadata from uploaded files.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	// ThumbnailSize bounds the longest side of generated thumbnails.
+	ThumbnailSize = 320
+	// maxPixels guards against decompression bombs.
+	maxPixels = 40_000_000
+	// blurhashSize is the size images are shrunk to before hashing.
+	blurhashSize = 32
+)
+
+var ErrTooLarge = errors.New("image dimensions too large")
+
+// ImageInfo describes a processed image.
+type ImageInfo struct {
+	Width           int
+	Height          int
+	Thumbnail       []byte
+	ThumbnailWidth  int
+	ThumbnailHeight int
+	Blurhash        string
+}
+
+// Supported reports whether previews can be generated for mimeType.
+func Supported(mimeType string) bool {
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// ProcessImage decodes an image and produces a JPEG thumbnail no larger than
+// ThumbnailSize on either side, along with its dimensions and a blurhash.
+func ProcessImage(r io.Reader) (*ImageInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image config: %w", err)
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+
+	info := &ImageInfo{Width: config.Width, Height: config.Height}
+
+	thumb := resize(img, ThumbnailSize)
+	info.ThumbnailWidth = thumb.Bounds().Dx()
+	info.ThumbnailHeight = thumb.Bounds().Dy()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	info.Thumbnail = buf.Bytes()
+
+	xComponents, yComponents := 4, 3
+	if info.Height > info.Width {
+		xComponents, yComponents = 3, 4
+	}
+	info.Blurhash, err = Blurhash(resize(thumb, blurhashSize), xComponents, yComponents)
+	if err != nil {
+		return nil, fmt.Errorf("blurhash: %w", err)
+	}
+
+	return info, nil
+}
+
+// resize scales img down so its longest side is at most maxSide, averaging
+// the source pixels covered by each destination pixel. Transparent areas are
+// flattened onto white. Images already small enough are only flattened.
+func resize(img image.Image, maxSide int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > maxSide || srcH > maxSide {
+		if srcW >= srcH {
+			dstW, dstH = maxSide, max(1, srcH*maxSide/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*maxSide/srcH), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := 0; dy < dstH; dy++ {
+		y0 := bounds.Min.Y + dy*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(dy+1)*srcH/dstH)
+		for dx := 0; dx < dstW; dx++ {
+			x0 := bounds.Min.X + dx*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(dx+1)*srcW/dstW)
+
+			var r, g, b, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := img.At(x, y).RGBA()
+					// Composite premultiplied colour over white.
+					white := 0xffff - uint64(pa)
+					r += uint64(pr) + white
+					g += uint64(pg) + white
+					b += uint64(pb) + white
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
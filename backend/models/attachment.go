@@ -0,0 +1,46 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Attachment struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	MessageID       *uint      `json:"message_id,omitempty" gorm:"index"`
+	RoomID          uint       `json:"room_id" gorm:"not null;index"`
+	UploaderID      uint       `json:"uploader_id" gorm:"not null"`
+	FileName        string     `json:"file_name" gorm:"not null"`
+	MimeType        string     `json:"mime_type" gorm:"not null"`
+	Size            int64      `json:"size"`
+	Checksum        string     `json:"checksum" gorm:"size:64"`
+	StorageKey      string     `json:"-" gorm:"not null"`
+	URL             string     `json:"url" gorm:"-"`
+	Width           int        `json:"width,omitempty"`
+	Height          int        `json:"height,omitempty"`
+	ThumbnailKey    string     `json:"-"`
+	ThumbnailURL    string     `json:"thumbnail_url,omitempty" gorm:"-"`
+	ThumbnailWidth  int        `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int        `json:"thumbnail_height,omitempty"`
+	Blurhash        string     `json:"blurhash,omitempty"`
+	ProcessedAt     *time.Time `json:"processed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = fmt.Sprintf("/api/attachments/%d/thumbnail", a.ID)
+	}
+	return nil
+}
+
+// BlobKeys returns the storage keys of the file and its derived previews.
+func (a *Attachment) BlobKeys() []string {
+	if a.ThumbnailKey == "" {
+		return []string{a.StorageKey}
+	}
+	return []string{a.StorageKey, a.ThumbnailKey}
+}
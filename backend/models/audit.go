@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditRoomCreate       = "room.create"
+	AuditRoomUpdate       = "room.update"
+	AuditRoomDelete       = "room.delete"
+	AuditMemberAdd        = "member.add"
+	AuditMemberRemove     = "member.remove"
+	AuditMemberRoleUpdate = "member.role_update"
+	AuditMemberMute       = "member.mute"
+)
+
+var ErrAuditAppendOnly = errors.New("room audit entries are append-only")
+
+// RoomAuditEntry records a single administrative change to a room.
+type RoomAuditEntry struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	RoomID       uint      `json:"room_id" gorm:"not null;index"`
+	ActorID      uint      `json:"actor_id" gorm:"not null;index"`
+	Actor        User      `json:"actor" gorm:"foreignKey:ActorID"`
+	Action       string    `json:"action" gorm:"not null;index"`
+	TargetUserID *uint     `json:"target_user_id,omitempty"`
+	Before       JSONText  `json:"before" gorm:"type:jsonb"`
+	After        JSONText  `json:"after" gorm:"type:jsonb"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+}
+
+func (RoomAuditEntry) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (RoomAuditEntry) BeforeDelete(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Bookmark is a message a user saved for later, with an optional note and
+// a reminder delivered as a notification at RemindAt.
+type Bookmark struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_bookmark_user_message"`
+	MessageID  uint       `json:"message_id" gorm:"not null;uniqueIndex:idx_bookmark_user_message;index"`
+	Message    Message    `json:"message" gorm:"foreignKey:MessageID"`
+	RoomID     uint       `json:"room_id" gorm:"not null"`
+	Note       string     `json:"note"`
+	RemindAt   *time.Time `json:"remind_at,omitempty" gorm:"index"`
+	RemindedAt *time.Time `json:"reminded_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
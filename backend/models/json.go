@@ -0,0 +1,38 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// JSONText holds a JSON document stored in a jsonb column. It is encoded
+// as-is in API responses; an empty value is stored as NULL.
+type JSONText string
+
+func (j JSONText) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+func (j JSONText) Value() (driver.Value, error) {
+	if j == "" {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSONText) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = ""
+	case []byte:
+		*j = JSONText(v)
+	case string:
+		*j = JSONText(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONText", src)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// LinkPreview caches the unfurled metadata of a URL. Failed fetches are
+// cached too, so a broken link is not retried for every message.
+type LinkPreview struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	URL         string    `json:"url" gorm:"not null;uniqueIndex"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	Failed      bool      `json:"-" gorm:"default:false"`
+	FetchedAt   time.Time `json:"-"`
+}
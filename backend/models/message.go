@@ -6,14 +6,78 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	MessageFormatPlain    = "plain"
+	MessageFormatMarkdown = "markdown"
+)
+
+const (
+	MessageTypeText = "text"
+	MessageTypePoll = "poll"
+	// MessageTypeAction is a /me message, shown as an action by its author.
+	MessageTypeAction = "action"
+)
+
 type Message struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Content   string         `json:"content" gorm:"not null"`
-	UserID    uint           `json:"user_id" gorm:"not null"`
-	User      User           `json:"user" gorm:"foreignKey:UserID"`
-	RoomID    uint           `json:"room_id" gorm:"not null"`
-	Room      Room           `json:"room" gorm:"foreignKey:RoomID"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID              uint              `json:"id" gorm:"primaryKey"`
+	Content         string            `json:"content" gorm:"not null"`
+	Format          string            `json:"format" gorm:"size:16;default:'plain'"`
+	Type            string            `json:"type" gorm:"size:16;default:'text'"`
+	ContentHTML     string            `json:"content_html,omitempty"`
+	ContentText     string            `json:"-"`
+	UserID          uint              `json:"user_id" gorm:"not null;uniqueIndex:idx_messages_client_id,priority:1"`
+	User            User              `json:"user" gorm:"foreignKey:UserID"`
+	RoomID          uint              `json:"room_id" gorm:"not null"`
+	Room            Room              `json:"room" gorm:"foreignKey:RoomID"`
+	ParentID        *uint             `json:"parent_id,omitempty" gorm:"index"`
+	ClientMessageID *string           `json:"client_message_id,omitempty" gorm:"size:64;uniqueIndex:idx_messages_client_id,priority:2"`
+	ReplyCount      int               `json:"reply_count" gorm:"-"`
+	LastReplyAt     *time.Time        `json:"last_reply_at,omitempty" gorm:"-"`
+	LastReplyUserID *uint             `json:"last_reply_user_id,omitempty" gorm:"-"`
+	IsEdited        bool              `json:"is_edited" gorm:"default:false"`
+	EditedAt        *time.Time        `json:"edited_at,omitempty"`
+	IsDeleted       bool              `json:"is_deleted" gorm:"-"`
+	RemovedAt       *time.Time        `json:"removed_at,omitempty" gorm:"index"`
+	RemovedBy       *uint             `json:"removed_by,omitempty"`
+	PurgedAt        *time.Time        `json:"-"`
+	ExpiresAt       *time.Time        `json:"expires_at,omitempty" gorm:"index"`
+	Reactions       []ReactionSummary `json:"reactions" gorm:"-"`
+	Mentions        []uint            `json:"mentions,omitempty" gorm:"-"`
+	Attachments     []Attachment      `json:"attachments" gorm:"foreignKey:MessageID"`
+	LinkPreviews    []LinkPreview     `json:"link_previews" gorm:"many2many:message_link_previews"`
+	Poll            *Poll             `json:"poll,omitempty" gorm:"foreignKey:MessageID"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt    `json:"-" gorm:"index"`
+}
+
+// AfterFind turns deleted messages into tombstones. The row is kept so that
+// replies still have a parent, but its content is never returned. Expired
+// disappearing messages are treated the same until the sweeper removes them.
+func (m *Message) AfterFind(tx *gorm.DB) error {
+	if m.RemovedAt != nil || m.IsExpired() {
+		m.IsDeleted = true
+		m.Content = ""
+		m.ContentHTML = ""
+		m.ContentText = ""
+		m.Attachments = nil
+		m.LinkPreviews = nil
+		m.Poll = nil
+	}
+	return nil
+}
+
+// IsExpired reports whether a disappearing message has passed its expiry.
+func (m *Message) IsExpired() bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(time.Now())
+}
+
+// MessageRevision keeps the content a message had before an edit.
+type MessageRevision struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	MessageID uint      `json:"message_id" gorm:"not null;index"`
+	Content   string    `json:"content" gorm:"not null"`
+	Format    string    `json:"format" gorm:"size:16;default:'plain'"`
+	EditedBy  uint      `json:"edited_by"`
+	CreatedAt time.Time `json:"created_at"`
 }
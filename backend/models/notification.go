@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	NotificationTypeMention  = "mention"
+	NotificationTypeReminder = "reminder"
+)
+
+// MessageMention links a message to a user it mentions, directly or through
+// @here and @room.
+type MessageMention struct {
+	MessageID uint `json:"message_id" gorm:"primaryKey"`
+	UserID    uint `json:"user_id" gorm:"primaryKey;index"`
+}
+
+type Notification struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Type      string     `json:"type" gorm:"not null"`
+	RoomID    uint       `json:"room_id"`
+	MessageID uint       `json:"message_id"`
+	ActorID   uint       `json:"actor_id"`
+	Actor     User       `json:"actor" gorm:"foreignKey:ActorID"`
+	Preview   string     `json:"preview"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
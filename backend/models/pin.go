@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// PinnedMessage marks a message as pinned in its room.
+type PinnedMessage struct {
+	MessageID uint      `json:"message_id" gorm:"primaryKey"`
+	Message   Message   `json:"message" gorm:"foreignKey:MessageID"`
+	RoomID    uint      `json:"room_id" gorm:"not null;index"`
+	PinnedBy  uint      `json:"pinned_by" gorm:"not null"`
+	Pinner    User      `json:"pinner" gorm:"foreignKey:PinnedBy"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
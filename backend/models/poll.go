@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Poll is attached to a message of type poll. Members vote on its options
+// until it is closed, either by hand or when ClosesAt passes.
+type Poll struct {
+	ID             uint         `json:"id" gorm:"primaryKey"`
+	MessageID      uint         `json:"message_id" gorm:"not null;uniqueIndex"`
+	MultipleChoice bool         `json:"multiple_choice" gorm:"default:false"`
+	Anonymous      bool         `json:"anonymous" gorm:"default:false"`
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	ClosedAt       *time.Time   `json:"closed_at,omitempty"`
+	IsClosed       bool         `json:"is_closed" gorm:"-"`
+	TotalVoters    int          `json:"total_voters" gorm:"-"`
+	Options        []PollOption `json:"options" gorm:"foreignKey:PollID"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// AfterFind marks polls whose close time has passed as closed.
+func (p *Poll) AfterFind(tx *gorm.DB) error {
+	p.IsClosed = p.Closed()
+	return nil
+}
+
+// Closed reports whether the poll no longer accepts votes.
+func (p *Poll) Closed() bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !p.ClosesAt.After(time.Now()))
+}
+
+type PollOption struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	PollID    uint   `json:"poll_id" gorm:"not null;index"`
+	Position  int    `json:"position"`
+	Text      string `json:"text" gorm:"not null"`
+	VoteCount int    `json:"vote_count" gorm:"-"`
+	VotedByMe bool   `json:"voted_by_me" gorm:"-"`
+	// Voters is only filled in for polls with visible voting.
+	Voters []uint `json:"voters,omitempty" gorm:"-"`
+}
+
+type PollVote struct {
+	PollID    uint      `json:"poll_id" gorm:"primaryKey"`
+	OptionID  uint      `json:"option_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type MessageReaction struct {
+	MessageID uint      `json:"message_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey"`
+	Emoji     string    `json:"emoji" gorm:"primaryKey;size:64"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReactionSummary aggregates the reactions with one emoji on a message.
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Reminder is a note a user asked to be reminded of with /remind. It is
+// delivered as a notification at RemindAt.
+type Reminder struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	RoomID    uint       `json:"room_id" gorm:"not null"`
+	Text      string     `json:"text" gorm:"not null"`
+	RemindAt  time.Time  `json:"remind_at" gorm:"not null;index"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -6,23 +6,63 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	RoomRoleOwner     = "owner"
+	RoomRoleAdmin     = "admin"
+	RoomRoleModerator = "moderator"
+	RoomRoleMember    = "member"
+)
+
+const (
+	// PostingPolicyEveryone lets every member post in the room.
+	PostingPolicyEveryone = "everyone"
+	// PostingPolicyAdmins makes the room read-only for everyone except
+	// the owner and admins.
+	PostingPolicyAdmins = "admins"
+)
+
 type Room struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"not null"`
-	Description string         `json:"description"`
-	IsPrivate   bool           `json:"is_private" gorm:"default:false"`
-	OwnerID     uint           `json:"owner_id"`
-	Owner       User           `json:"owner" gorm:"foreignKey:OwnerID"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
-	Messages    []Message      `json:"-" gorm:"foreignKey:RoomID"`
-	Members     []User         `json:"members" gorm:"many2many:room_members;"`
+	ID                     uint           `json:"id" gorm:"primaryKey"`
+	WorkspaceID            uint           `json:"workspace_id" gorm:"index"`
+	Name                   string         `json:"name" gorm:"not null"`
+	Description            string         `json:"description"`
+	IsPrivate              bool           `json:"is_private" gorm:"default:false"`
+	SlowModeSeconds        int            `json:"slow_mode_seconds" gorm:"default:0"`
+	PostingPolicy          string         `json:"posting_policy" gorm:"default:'everyone'"`
+	EditWindowSeconds      int            `json:"edit_window_seconds" gorm:"default:900"`
+	MaxAttachmentBytes     int64          `json:"max_attachment_bytes" gorm:"default:10485760"`
+	AllowedAttachmentTypes string         `json:"allowed_attachment_types"`
+	MessageTTLSeconds      int            `json:"message_ttl_seconds" gorm:"default:0"`
+	CanPost                bool           `json:"can_post" gorm:"-"`
+	LastReadMessageID      uint           `json:"last_read_message_id" gorm:"-"`
+	UnreadCount            int            `json:"unread_count" gorm:"-"`
+	MentionCount           int            `json:"mention_count" gorm:"-"`
+	OwnerID                uint           `json:"owner_id"`
+	Owner                  User           `json:"owner" gorm:"foreignKey:OwnerID"`
+	CreatedAt              time.Time      `json:"created_at"`
+	UpdatedAt              time.Time      `json:"updated_at"`
+	DeletedAt              gorm.DeletedAt `json:"-" gorm:"index"`
+	Messages               []Message      `json:"-" gorm:"foreignKey:RoomID"`
+	Members                []User         `json:"members" gorm:"many2many:room_members;"`
 }
 
 type RoomMember struct {
-	RoomID    uint      `gorm:"primaryKey"`
-	UserID    uint      `gorm:"primaryKey"`
-	JoinedAt  time.Time `json:"joined_at"`
-	InvitedBy uint      `json:"invited_by"`
+	RoomID            uint       `gorm:"primaryKey"`
+	UserID            uint       `gorm:"primaryKey"`
+	Role              string     `json:"role" gorm:"default:'member'"`
+	JoinedAt          time.Time  `json:"joined_at"`
+	InvitedBy         uint       `json:"invited_by"`
+	LastReadMessageID uint       `json:"last_read_message_id" gorm:"default:0"`
+	LastReadAt        *time.Time `json:"last_read_at"`
+	MutedUntil        *time.Time `json:"muted_until,omitempty"`
+}
+
+// IsAdmin reports whether the member administers the room.
+func (m RoomMember) IsAdmin() bool {
+	return m.Role == RoomRoleOwner || m.Role == RoomRoleAdmin
+}
+
+// IsModerator reports whether the member may moderate the room.
+func (m RoomMember) IsModerator() bool {
+	return m.Role == RoomRoleOwner || m.Role == RoomRoleAdmin || m.Role == RoomRoleModerator
 }
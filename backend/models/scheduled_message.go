@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	ScheduledStatusPending  = "pending"
+	ScheduledStatusSent     = "sent"
+	ScheduledStatusFailed   = "failed"
+	ScheduledStatusCanceled = "canceled"
+)
+
+// ScheduledMessage is a message composed now and posted at SendAt. The
+// timezone it was scheduled in is kept so clients can show and edit the
+// time as the author entered it.
+type ScheduledMessage struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	RoomID    uint       `json:"room_id" gorm:"not null;index"`
+	ParentID  *uint      `json:"parent_id,omitempty"`
+	Content   string     `json:"content" gorm:"not null"`
+	Format    string     `json:"format" gorm:"size:16;default:'plain'"`
+	SendAt    time.Time  `json:"send_at" gorm:"not null;index:idx_scheduled_due,priority:2"`
+	Timezone  string     `json:"timezone" gorm:"size:64;not null;default:'UTC'"`
+	Status    string     `json:"status" gorm:"size:16;not null;default:'pending';index:idx_scheduled_due,priority:1"`
+	MessageID *uint      `json:"message_id,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
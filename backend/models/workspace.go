@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WorkspaceRoleOwner  = "owner"
+	WorkspaceRoleAdmin  = "admin"
+	WorkspaceRoleMember = "member"
+)
+
+// DefaultWorkspaceSlug identifies the workspace every user implicitly
+// belongs to. Rooms created before workspaces existed are moved into it.
+const DefaultWorkspaceSlug = "default"
+
+type Workspace struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"not null"`
+	Slug      string         `json:"slug" gorm:"uniqueIndex;not null"`
+	OwnerID   uint           `json:"owner_id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	Rooms     []Room         `json:"-" gorm:"foreignKey:WorkspaceID"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID uint      `json:"workspace_id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"primaryKey"`
+	Role        string    `json:"role" gorm:"default:'member'"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// IsAdmin reports whether the member administers the workspace.
+func (m WorkspaceMember) IsAdmin() bool {
+	return m.Role == WorkspaceRoleOwner || m.Role == WorkspaceRoleAdmin
+}
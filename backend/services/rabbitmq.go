@@ -17,6 +17,8 @@ var (
 const (
 	ChatExchange = "chat_exchange"
 	ChatQueue    = "chat_queue"
+	MediaQueue   = "media_queue"
+	UnfurlQueue  = "unfurl_queue"
 )
 
 type MessageEvent struct {
@@ -29,6 +31,16 @@ type MessageEvent struct {
 	Data      interface{} `json:"data,omitempty"`
 }
 
+// MediaJob asks the media worker to process an uploaded attachment.
+type MediaJob struct {
+	AttachmentID uint `json:"attachment_id"`
+}
+
+// UnfurlJob asks the unfurl worker to fetch link previews for a message.
+type UnfurlJob struct {
+	MessageID uint `json:"message_id"`
+}
+
 func InitRabbitMQ() {
 	host := getEnv("RABBITMQ_HOST", "localhost")
 	port := getEnv("RABBITMQ_PORT", "5672")
@@ -84,6 +96,20 @@ func InitRabbitMQ() {
 		log.Fatalf("Failed to bind a queue: %v", err)
 	}
 
+	for _, queue := range []string{MediaQueue, UnfurlQueue} {
+		_, err = RabbitMQChan.QueueDeclare(
+			queue,
+			true,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			log.Fatalf("Failed to declare a queue: %v", err)
+		}
+	}
+
 	log.Println("RabbitMQ connected successfully")
 }
 
@@ -138,6 +164,88 @@ func ConsumeMessages(handler func(MessageEvent)) error {
 	return nil
 }
 
+// PublishMediaJob queues an attachment for media processing.
+func PublishMediaJob(job MediaJob) error {
+	return publishJob(MediaQueue, job)
+}
+
+// ConsumeMediaJobs runs handler for each queued media job.
+func ConsumeMediaJobs(handler func(MediaJob) error) error {
+	return consumeJobs(MediaQueue, func(body []byte) error {
+		var job MediaJob
+		if err := json.Unmarshal(body, &job); err != nil {
+			return err
+		}
+		return handler(job)
+	})
+}
+
+// PublishUnfurlJob queues a message for link preview fetching.
+func PublishUnfurlJob(job UnfurlJob) error {
+	return publishJob(UnfurlQueue, job)
+}
+
+// ConsumeUnfurlJobs runs handler for each queued unfurl job.
+func ConsumeUnfurlJobs(handler func(UnfurlJob) error) error {
+	return consumeJobs(UnfurlQueue, func(body []byte) error {
+		var job UnfurlJob
+		if err := json.Unmarshal(body, &job); err != nil {
+			return err
+		}
+		return handler(job)
+	})
+}
+
+// publishJob sends a persistent job straight to a work queue rather than
+// through the chat fanout.
+func publishJob(queue string, job interface{}) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return RabbitMQChan.Publish(
+		"",
+		queue,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+}
+
+// consumeJobs runs handle for each job on a work queue. A job is
+// acknowledged once handle returns; failed jobs are logged and dropped so a
+// bad payload cannot block the queue.
+func consumeJobs(queue string, handle func([]byte) error) error {
+	jobs, err := RabbitMQChan.Consume(
+		queue,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range jobs {
+			if err := handle(msg.Body); err != nil {
+				log.Printf("Error processing job from %s: %v", queue, err)
+			}
+			msg.Ack(false)
+		}
+	}()
+
+	return nil
+}
+
 func CloseRabbitMQ() {
 	if RabbitMQChan != nil {
 		RabbitMQChan.Close()
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// typingThrottle is the minimum interval between typing_start events
+	// relayed for one client.
+	typingThrottle = 3 * time.Second
+
+	// typingTimeout is how long a typing indicator lasts without being
+	// refreshed before typing_stop is sent on the client's behalf.
+	typingTimeout = 6 * time.Second
+)
+
+// TypingGuard reports whether a client may show a typing indicator in its
+// room, e.g. whether its user may post there.
+type TypingGuard func(client *Client) bool
+
+// SetTypingGuard registers the check run before typing_start is relayed.
+func (manager *WebSocketManager) SetTypingGuard(guard TypingGuard) {
+	manager.typingMu.Lock()
+	defer manager.typingMu.Unlock()
+
+	manager.typingGuard = guard
+}
+
+type typingState struct {
+	lastSent time.Time
+	timer    *time.Timer
+	// generation identifies the current timer. A timer that fired while a
+	// newer start was waiting for the lock sees a different generation and
+	// does nothing.
+	generation uint64
+}
+
+// handleTyping consumes typing_start and typing_stop events. They are fanned
+// out to the rest of the room and never reach other filters or storage.
+func (manager *WebSocketManager) handleTyping(client *Client, message []byte) bool {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &event); err != nil {
+		return true
+	}
+
+	switch event.Type {
+	case "typing_start":
+		if manager.mayType(client) {
+			manager.startTyping(client)
+		} else {
+			manager.stopTyping(client)
+		}
+		return false
+	case "typing_stop":
+		manager.stopTyping(client)
+		return false
+	case "message", "new_message":
+		manager.stopTyping(client)
+	}
+	return true
+}
+
+func (manager *WebSocketManager) mayType(client *Client) bool {
+	manager.typingMu.Lock()
+	guard := manager.typingGuard
+	manager.typingMu.Unlock()
+
+	return guard == nil || guard(client)
+}
+
+func (manager *WebSocketManager) startTyping(client *Client) {
+	manager.typingMu.Lock()
+	defer manager.typingMu.Unlock()
+
+	state, ok := manager.typing[client]
+	if !ok {
+		state = &typingState{}
+		manager.typing[client] = state
+	} else {
+		state.timer.Stop()
+	}
+	state.generation++
+	generation := state.generation
+	state.timer = time.AfterFunc(typingTimeout, func() {
+		manager.expireTyping(client, state, generation)
+	})
+
+	if time.Since(state.lastSent) < typingThrottle {
+		return
+	}
+	state.lastSent = time.Now()
+	manager.BroadcastToRoomExcept(client.RoomID, typingEvent("typing_start", client), client)
+}
+
+func (manager *WebSocketManager) stopTyping(client *Client) {
+	manager.typingMu.Lock()
+	defer manager.typingMu.Unlock()
+
+	state, ok := manager.typing[client]
+	if !ok {
+		return
+	}
+	manager.endTyping(client, state)
+}
+
+// expireTyping stops the indicator when its timer fires, unless the timer
+// was replaced or the indicator ended in the meantime.
+func (manager *WebSocketManager) expireTyping(client *Client, state *typingState, generation uint64) {
+	manager.typingMu.Lock()
+	defer manager.typingMu.Unlock()
+
+	if manager.typing[client] != state || state.generation != generation {
+		return
+	}
+	manager.endTyping(client, state)
+}
+
+// endTyping must be called with typingMu held.
+func (manager *WebSocketManager) endTyping(client *Client, state *typingState) {
+	state.timer.Stop()
+	delete(manager.typing, client)
+
+	manager.BroadcastToRoomExcept(client.RoomID, typingEvent("typing_stop", client), client)
+}
+
+func typingEvent(eventType string, client *Client) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":      eventType,
+		"user_id":   client.ID,
+		"username":  client.Username,
+		"room_id":   client.RoomID,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+	return data
+}
@@ -18,6 +18,10 @@ type Client struct {
 	LastActive time.Time
 }
 
+// MessageFilter is called for every message a client sends before it is
+// relayed to the room. Returning false drops the message.
+type MessageFilter func(client *Client, message []byte) bool
+
 type WebSocketManager struct {
 	Clients    map[*Client]bool
 	Broadcast  chan []byte
@@ -26,18 +30,26 @@ type WebSocketManager struct {
 	RoomMap    map[uint]map[*Client]bool
 	// Map to track clients by user ID and room ID
 	UserRoomMap map[string]*Client
+	filters     []MessageFilter
 	mu          sync.Mutex
+
+	typing      map[*Client]*typingState
+	typingGuard TypingGuard
+	typingMu    sync.Mutex
 }
 
 func NewWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{
+	manager := &WebSocketManager{
 		Clients:     make(map[*Client]bool),
 		Broadcast:   make(chan []byte),
 		Register:    make(chan *Client),
 		Unregister:  make(chan *Client),
 		RoomMap:     make(map[uint]map[*Client]bool),
 		UserRoomMap: make(map[string]*Client),
+		typing:      make(map[*Client]*typingState),
 	}
+	manager.filters = []MessageFilter{manager.handleTyping}
+	return manager
 }
 
 // Get a unique key for a user ID and room ID combination
@@ -125,6 +137,123 @@ func (manager *WebSocketManager) BroadcastToRoom(roomID uint, message []byte) {
 	}
 }
 
+// BroadcastToRoomExcept sends a message to every client in the room except one.
+func (manager *WebSocketManager) BroadcastToRoomExcept(roomID uint, message []byte, except *Client) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	for client := range manager.RoomMap[roomID] {
+		if client == except {
+			continue
+		}
+		select {
+		case client.Send <- message:
+		default:
+			log.Printf("Send buffer full for client %s (ID: %d), dropping message", client.Username, client.ID)
+		}
+	}
+}
+
+// SendToClient delivers a message to a single client connected to a room.
+func (manager *WebSocketManager) SendToClient(client *Client, message []byte) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if clients, ok := manager.RoomMap[client.RoomID]; !ok || !clients[client] {
+		return
+	}
+
+	select {
+	case client.Send <- message:
+	default:
+		log.Printf("Send buffer full for client %s (ID: %d), dropping message", client.Username, client.ID)
+	}
+}
+
+// SendToUser delivers a message to every connection of a user, whichever
+// room it is connected to.
+func (manager *WebSocketManager) SendToUser(userID uint, message []byte) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	for _, clients := range manager.RoomMap {
+		for client := range clients {
+			if client.ID != userID {
+				continue
+			}
+			select {
+			case client.Send <- message:
+			default:
+				log.Printf("Send buffer full for client %s (ID: %d), dropping message", client.Username, client.ID)
+			}
+		}
+	}
+}
+
+// DisconnectUser closes every connection userID has to the room, e.g. after
+// they were removed from it.
+func (manager *WebSocketManager) DisconnectUser(roomID, userID uint) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	for client := range manager.RoomMap[roomID] {
+		if client.ID != userID {
+			continue
+		}
+		close(client.Send)
+		delete(manager.Clients, client)
+		delete(manager.RoomMap[roomID], client)
+
+		userRoomKey := getUserRoomKey(client.ID, roomID)
+		if existingClient := manager.UserRoomMap[userRoomKey]; existingClient == client {
+			delete(manager.UserRoomMap, userRoomKey)
+		}
+	}
+	if len(manager.RoomMap[roomID]) == 0 {
+		delete(manager.RoomMap, roomID)
+	}
+}
+
+// Presence returns the users with at least one open connection and the users
+// connected to the given room.
+func (manager *WebSocketManager) Presence(roomID uint) (online map[uint]bool, inRoom map[uint]bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	online = make(map[uint]bool)
+	inRoom = make(map[uint]bool)
+	for id, clients := range manager.RoomMap {
+		for client := range clients {
+			online[client.ID] = true
+			if id == roomID {
+				inRoom[client.ID] = true
+			}
+		}
+	}
+	return online, inRoom
+}
+
+// AddFilter registers a filter for messages received from clients.
+func (manager *WebSocketManager) AddFilter(filter MessageFilter) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.filters = append(manager.filters, filter)
+}
+
+func (manager *WebSocketManager) allowMessage(client *Client, message []byte) bool {
+	manager.mu.Lock()
+	filters := manager.filters
+	manager.mu.Unlock()
+
+	for _, filter := range filters {
+		if !filter(client, message) {
+			return false
+		}
+	}
+	return true
+}
+
 func (manager *WebSocketManager) Start() {
 	for {
 		select {
@@ -137,6 +266,7 @@ func (manager *WebSocketManager) Start() {
 				delete(manager.Clients, client)
 				close(client.Send)
 				manager.RemoveClientFromRoom(client)
+				manager.stopTyping(client)
 				log.Printf("Client unregistered: %s (ID: %d)", client.Username, client.ID)
 			}
 
@@ -167,6 +297,9 @@ func (client *Client) HandleClient(manager *WebSocketManager) {
 				break
 			}
 			client.LastActive = time.Now()
+			if !manager.allowMessage(client, message) {
+				continue
+			}
 			manager.BroadcastToRoom(client.RoomID, message)
 		}
 	}()
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// ErrNotFound is returned when a blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores uploaded files by key.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var Store BlobStore
+
+// InitStorage configures the blob store selected by STORAGE_BACKEND
+// ("local" or "s3").
+func InitStorage() {
+	backend := getEnv("STORAGE_BACKEND", "local")
+
+	switch backend {
+	case "local":
+		store, err := NewLocalStore(getEnv("STORAGE_LOCAL_PATH", "./uploads"))
+		if err != nil {
+			log.Fatalf("Failed to initialize local storage: %v", err)
+		}
+		Store = store
+	case "s3":
+		Store = NewS3Store(S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    getEnv("S3_BUCKET", "appchat"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: getEnv("S3_PATH_STYLE", "true") == "true",
+		})
+	default:
+		log.Fatalf("Unknown storage backend: %s", backend)
+	}
+
+	log.Printf("Storage initialized: %s", backend)
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,362 @@
+// Package unfurl fetches link previews from OpenGraph tags and oEmbed
+// endpoints without letting message authors reach internal services.
+package unfurl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// MaxURLsPerMessage caps how many links of one message are unfurled.
+	MaxURLsPerMessage = 3
+	maxRedirects      = 3
+	maxTextLength     = 300
+)
+
+var (
+	ErrBlockedAddress = errors.New("address is not publicly routable")
+	ErrNotHTML        = errors.New("response is not an HTML page")
+	ErrNoMetadata     = errors.New("page has no preview metadata")
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// Preview is the metadata shown for a link.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	SiteName    string
+	ImageURL    string
+}
+
+// Fetcher retrieves previews over HTTP with strict time and size limits.
+type Fetcher struct {
+	Client   *http.Client
+	MaxBytes int64
+}
+
+// NewFetcher returns a Fetcher whose connections may only reach public
+// addresses. The check runs on the resolved IP at dial time, so redirects
+// and DNS rebinding cannot bypass it. allowPrivate disables the check and
+// exists for tests against local servers.
+func NewFetcher(timeout time.Duration, maxBytes int64, allowPrivate bool) *Fetcher {
+	if allowPrivate {
+		return newFetcher(timeout, maxBytes, nil)
+	}
+	return newFetcher(timeout, maxBytes, checkPublicAddress)
+}
+
+// newFetcher builds a Fetcher that runs checkAddress on every address it
+// dials, if set.
+func newFetcher(timeout time.Duration, maxBytes int64, checkAddress func(address string) error) *Fetcher {
+	dialer := &net.Dialer{Timeout: timeout}
+	if checkAddress != nil {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address)
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("unsupported redirect scheme %q", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		MaxBytes: maxBytes,
+	}
+}
+
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+)
+
+// checkPublicAddress rejects dial addresses that are not publicly routable.
+func checkPublicAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractURLs returns the distinct http(s) links in text, in order of
+// appearance and at most MaxURLsPerMessage of them.
+func ExtractURLs(text string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		u, err := url.Parse(match)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		u.Fragment = ""
+		normalized := u.String()
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		urls = append(urls, normalized)
+		if len(urls) == MaxURLsPerMessage {
+			break
+		}
+	}
+	return urls
+}
+
+// Fetch downloads rawURL and builds a preview from its OpenGraph and HTML
+// metadata, falling back to the page's oEmbed endpoint for missing fields.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	body, finalURL, contentType, err := f.get(ctx, rawURL, "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	reader, err := charset.NewReader(bytes.NewReader(body), "text/html; charset="+params["charset"])
+	if err != nil {
+		return nil, err
+	}
+	meta, err := parseMeta(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &Preview{
+		URL:         rawURL,
+		Title:       firstNonEmpty(meta["og:title"], meta["twitter:title"], meta["title"]),
+		Description: firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]),
+		SiteName:    meta["og:site_name"],
+		ImageURL:    firstNonEmpty(meta["og:image"], meta["og:image:url"], meta["twitter:image"]),
+	}
+
+	if (preview.Title == "" || preview.ImageURL == "") && meta["oembed"] != "" {
+		if oembedURL, err := finalURL.Parse(meta["oembed"]); err == nil {
+			f.applyOEmbed(ctx, oembedURL.String(), preview)
+		}
+	}
+
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoMetadata
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = finalURL.Hostname()
+	}
+	preview.ImageURL = resolveImageURL(finalURL, preview.ImageURL)
+	preview.Title = truncate(preview.Title, maxTextLength)
+	preview.Description = truncate(preview.Description, maxTextLength)
+	preview.SiteName = truncate(preview.SiteName, maxTextLength)
+
+	return preview, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, rawURL, accept string) ([]byte, *url.URL, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil, "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "AppChatBot/1.0 (link preview)")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// Previews only need the document head, so a truncated body is fine.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return body, resp.Request.URL, resp.Header.Get("Content-Type"), nil
+}
+
+type oembedResponse struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (f *Fetcher) applyOEmbed(ctx context.Context, oembedURL string, preview *Preview) {
+	body, _, _, err := f.get(ctx, oembedURL, "application/json")
+	if err != nil {
+		return
+	}
+	var data oembedResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return
+	}
+	preview.Title = firstNonEmpty(preview.Title, data.Title)
+	preview.Description = firstNonEmpty(preview.Description, data.AuthorName)
+	preview.SiteName = firstNonEmpty(preview.SiteName, data.ProviderName)
+	preview.ImageURL = firstNonEmpty(preview.ImageURL, data.ThumbnailURL)
+}
+
+// parseMeta collects <meta> properties, the <title> text and the oEmbed
+// discovery link from the document head.
+func parseMeta(r io.Reader) (map[string]string, error) {
+	meta := make(map[string]string)
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return meta, nil
+			}
+			return meta, tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "meta":
+				key := strings.ToLower(firstNonEmpty(attr(token, "property"), attr(token, "name")))
+				if key != "" && meta[key] == "" {
+					meta[key] = strings.TrimSpace(attr(token, "content"))
+				}
+			case "link":
+				if strings.EqualFold(attr(token, "rel"), "alternate") &&
+					strings.EqualFold(attr(token, "type"), "application/json+oembed") && meta["oembed"] == "" {
+					meta["oembed"] = attr(token, "href")
+				}
+			case "title":
+				inTitle = meta["title"] == ""
+			case "body":
+				return meta, nil
+			}
+		case html.TextToken:
+			if inTitle {
+				meta["title"] = strings.TrimSpace(string(tokenizer.Text()))
+				inTitle = false
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return meta, nil
+			}
+		}
+	}
+}
+
+func attr(token html.Token, name string) string {
+	for _, a := range token.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func resolveImageURL(base *url.URL, raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := base.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,236 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveHTML(page string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::7f00:1", false},
+		{"8.8.8.8", true},
+		{"93.184.216.34", true},
+		{"2606:4700:4700::1111", true},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestFetchBlocksLoopback(t *testing.T) {
+	server := serveHTML(`<title>internal</title>`)
+	defer server.Close()
+
+	fetcher := NewFetcher(time.Second, 1<<20, false)
+	_, err := fetcher.Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch = %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchBlocksRedirectToLoopback(t *testing.T) {
+	internal := serveHTML(`<title>internal</title>`)
+	defer internal.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1)
+		http.Redirect(w, r, target, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	// Stand in for a public server: only the redirector's address is let
+	// through, every other dial goes through the real check.
+	allowed := redirector.Listener.Addr().String()
+	fetcher := newFetcher(time.Second, 1<<20, func(address string) error {
+		if address == allowed {
+			return nil
+		}
+		return checkPublicAddress(address)
+	})
+
+	_, err := fetcher.Fetch(context.Background(), redirector.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch = %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchOpenGraph(t *testing.T) {
+	server := serveHTML(`<!doctype html><html><head>
+		<title>Fallback title</title>
+		<meta property="og:title" content="  Open Graph title ">
+		<meta property="og:description" content="Description">
+		<meta property="og:image" content="/images/cover.png">
+		<meta name="description" content="Plain description">
+		</head><body><meta property="og:site_name" content="Ignored"></body></html>`)
+	defer server.Close()
+
+	preview, err := NewFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL+"/post")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	want := Preview{
+		URL:         server.URL + "/post",
+		Title:       "Open Graph title",
+		Description: "Description",
+		SiteName:    strings.Split(host, ":")[0],
+		ImageURL:    server.URL + "/images/cover.png",
+	}
+	if *preview != want {
+		t.Errorf("Fetch = %+v, want %+v", *preview, want)
+	}
+}
+
+func TestFetchOEmbed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/video", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head>
+			<link rel="alternate" type="application/json+oembed" href="/oembed?url=video">
+			</head></html>`)
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") != "video" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title":"A video","author_name":"Someone","provider_name":"Tube","thumbnail_url":"https://img.example.com/t.jpg"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	preview, err := NewFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL+"/video")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.Title != "A video" || preview.Description != "Someone" ||
+		preview.SiteName != "Tube" || preview.ImageURL != "https://img.example.com/t.jpg" {
+		t.Errorf("Fetch = %+v", *preview)
+	}
+}
+
+func TestFetchKeepsTitleMarkupAsText(t *testing.T) {
+	server := serveHTML(`<html><head>
+		<title><script>alert(1)</script></title>
+		<meta property="og:description" content="&lt;img src=x onerror=alert(1)&gt; &amp; more">
+		</head></html>`)
+	defer server.Close()
+
+	preview, err := NewFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	// Previews are plain text; markup in the page stays literal and is
+	// escaped wherever the client renders it.
+	if preview.Title != "<script>alert(1)</script>" {
+		t.Errorf("Title = %q", preview.Title)
+	}
+	if preview.Description != "<img src=x onerror=alert(1)> & more" {
+		t.Errorf("Description = %q", preview.Description)
+	}
+}
+
+func TestFetchRejectsJavascriptImage(t *testing.T) {
+	server := serveHTML(`<head><title>t</title><meta property="og:image" content="javascript:alert(1)"></head>`)
+	defer server.Close()
+
+	preview, err := NewFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.ImageURL != "" {
+		t.Errorf("ImageURL = %q, want empty", preview.ImageURL)
+	}
+}
+
+func TestFetchReadsAtMostMaxBytes(t *testing.T) {
+	padding := strings.Repeat("<!-- padding -->", 1024)
+	server := serveHTML(`<html><head>` + padding + `<title>Too far</title></head></html>`)
+	defer server.Close()
+
+	_, err := NewFetcher(time.Second, 1024, true).Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrNoMetadata) {
+		t.Fatalf("Fetch = %v, want ErrNoMetadata", err)
+	}
+
+	preview, err := NewFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL)
+	if err != nil || preview.Title != "Too far" {
+		t.Fatalf("Fetch without the cap = %+v, %v", preview, err)
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := NewFetcher(100*time.Millisecond, 1<<20, true).Fetch(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("Fetch succeeded, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %v", elapsed)
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher(time.Second, 1<<20, true).Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrNotHTML) {
+		t.Fatalf("Fetch = %v, want ErrNotHTML", err)
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	got := ExtractURLs("see https://a.example/x, (https://b.example/y) https://a.example/x#top and https://c.example https://d.example")
+	want := []string{"https://a.example/x", "https://b.example/y", "https://c.example"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ExtractURLs = %v, want %v", got, want)
+	}
+}
@@ -105,7 +105,11 @@ const MessageItem = ({ message, currentUser }) => {
             {message.user.username}
           </Typography>
         )}
-        <Typography variant="body1">{message.content}</Typography>
+        {message.content_html ? (
+          <Typography variant="body1" component="div" dangerouslySetInnerHTML={{ __html: message.content_html }} />
+        ) : (
+          <Typography variant="body1">{message.content}</Typography>
+        )}
         <Typography variant="caption" color={isOwnMessage ? 'rgba(255,255,255,0.7)' : 'textSecondary'} sx={{ display: 'block', mt: 1 }}>
           {new Date(message.created_at).toLocaleTimeString()}
           {message.is_pending && ' (Отправляется...)'}
@@ -229,7 +233,7 @@ const Chat = () => {
     if (roomId) {
       roomsAPI.getRoomMembers(roomId)
         .then(response => {
-          setMembers(response.data);
+          setMembers(response.data.members);
         })
         .catch(error => {
           console.error('Ошибка при загрузке участников:', error);
@@ -289,8 +293,10 @@ const Chat = () => {
           
           let newMessage;
           
-          // Если сообщение содержит все данные, используем их как есть
-          if (message.id && message.user) {
+          // Готовую разметку (content_html) принимаем только из new_message,
+          // который собирает сервер из сохраненного сообщения. Остальные
+          // кадры показываем как обычный текст.
+          if (message.type === 'new_message' && message.id && message.user) {
             console.log('[DEBUG] Message has complete data with ID and user');
             newMessage = message;
           } else {
@@ -348,6 +354,14 @@ const Chat = () => {
           
           // Для полных сообщений обрабатываем здесь
           setMessages(prevMessages => {
+            // Заменяем оптимистичное сообщение, отправленное с тем же client_message_id
+            if (newMessage.client_message_id &&
+                prevMessages.some(m => m.client_message_id === newMessage.client_message_id)) {
+              return prevMessages.map(m =>
+                m.client_message_id === newMessage.client_message_id ? { ...newMessage, is_pending: false } : m
+              );
+            }
+
             // Проверяем, не дублируется ли сообщение
             console.log('[DEBUG] Checking for duplicate against', prevMessages.length, 'existing messages');
             const messageExists = prevMessages.some(m => {
@@ -407,7 +421,7 @@ const Chat = () => {
         roomsAPI.getRoomMembers(roomId)
           .then(response => {
             console.log('[DEBUG] New members list received:', response.data);
-            setMembers(response.data);
+            setMembers(response.data.members);
           })
           .catch(error => {
             console.error('[DEBUG] Error updating members:', error);
@@ -534,7 +548,7 @@ const Chat = () => {
     console.log('[DEBUG] Sending message:', messageText);
     
     // Создаем временный ID для оптимистичного обновления UI
-    const tempId = `temp-${Date.now()}`;
+    const tempId = `temp-${Date.now()}-${Math.random().toString(36).slice(2, 10)}`;
     
     // Создаем временное сообщение для отображения до получения ответа от сервера
     const tempMessage = {
@@ -543,6 +557,7 @@ const Chat = () => {
       room_id: parseInt(roomId),
       user: currentUser,
       created_at: new Date().toISOString(),
+      client_message_id: tempId,
       is_pending: true // Маркер, что сообщение еще не подтверждено сервером
     };
     
@@ -554,34 +569,24 @@ const Chat = () => {
     // Очищаем поле ввода
     setMessageText('');
     
-    // Сначала пытаемся отправить через WebSocket для более быстрой доставки
-    let wsMessageSent = false;
-    if (webSocketRef.current && webSocketRef.current.isConnected()) {
-      try {
-        console.log('[DEBUG] Sending message via WebSocket');
-        const wsMessage = {
-          type: 'message',
-          content: messageText,
-          room_id: parseInt(roomId),
-          user_id: currentUser.id,
-          temp_id: tempId // Добавляем tempId чтобы идентифицировать это сообщение
-        };
-        webSocketRef.current.sendMessage(wsMessage);
-        wsMessageSent = true;
-        console.log('[DEBUG] Successfully sent message via WebSocket:', wsMessage);
-      } catch (error) {
-        console.error('[DEBUG] Error sending WebSocket message:', error);
-        wsMessageSent = false;
-      }
-    } else {
-      console.log('[DEBUG] WebSocket not connected, falling back to HTTP');
-    }
-
-    // Всегда отправляем через REST API для надежности, даже если WebSocket отправка успешна
+    // Сообщения сохраняются через REST API; остальным участникам сервер
+    // рассылает сохраненное сообщение через WebSocket
+    const wsConnected = webSocketRef.current && webSocketRef.current.isConnected();
     console.log('[DEBUG] Sending message via REST API');
-    messagesAPI.createMessage(messageText, parseInt(roomId))
+    messagesAPI.createMessage(messageText, parseInt(roomId), tempId)
       .then(response => {
         console.log('[DEBUG] API response success:', response.data);
+        // Слэш-команда: сервер возвращает ответ команды вместо сообщения
+        if (response.data.command !== undefined) {
+          const reply = response.data;
+          setMessages(prevMessages => reply.message
+            ? prevMessages.map(msg => msg.id === tempId ? { ...reply.message, is_pending: false } : msg)
+            : prevMessages.filter(msg => msg.id !== tempId));
+          if (reply.ephemeral) {
+            alert(reply.ephemeral);
+          }
+          return;
+        }
         // Обновляем локальный список сообщений - заменяем временное сообщение на настоящее
         setMessages(prevMessages => 
           prevMessages.map(msg => 
@@ -589,9 +594,9 @@ const Chat = () => {
           )
         );
         
-        // Если не удалось отправить через WebSocket, но API запрос успешен,
+        // Если WebSocket не подключен, но API запрос успешен,
         // пытаемся переподключить WebSocket
-        if (!wsMessageSent && webSocketRef.current) {
+        if (!wsConnected && webSocketRef.current) {
           console.log('[DEBUG] Attempting to reconnect WebSocket after REST API success');
           webSocketRef.current.disconnect();
           webSocketRef.current.connect();
@@ -166,14 +166,21 @@ export const roomsAPI = {
 
 // API методы для сообщений
 export const messagesAPI = {
-  // Получить сообщения комнаты
-  getMessages: (roomId) => {
-    return api.get(`/messages/room/${roomId}`);
+  // Получить сообщения комнаты (params: before, after, around, limit)
+  getMessages: (roomId, params = {}) => {
+    return api.get(`/messages/room/${roomId}`, { params })
+      .then(response => ({
+        ...response,
+        data: response.data.messages,
+        hasMoreBefore: response.data.has_more_before,
+        hasMoreAfter: response.data.has_more_after,
+      }));
   },
 
-  // Создать новое сообщение
-  createMessage: (content, roomId) => {
-    return api.post('/messages', { content, room_id: roomId });
+  // Создать новое сообщение. clientMessageId позволяет безопасно повторять
+  // запрос: сервер вернет уже созданное сообщение вместо дубликата
+  createMessage: (content, roomId, clientMessageId) => {
+    return api.post('/messages', { content, room_id: roomId, client_message_id: clientMessageId });
   },
 };
 